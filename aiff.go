@@ -0,0 +1,115 @@
+package jlsampler
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+)
+
+// ----------------------------------------------------------------------------
+// LoadAiff: Load an AIFF or AIFF-C file. Supported AIFF-C compression types
+// are uncompressed big and little-endian integer and 32/64-bit float.
+func LoadAiff(path string) (*PcmData, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 12 || string(data[0:4]) != "FORM" {
+		return nil, errors.New("Not an aiff file: " + path)
+	}
+
+	isAifc := false
+	switch string(data[8:12]) {
+	case "AIFF":
+	case "AIFC":
+		isAifc = true
+	default:
+		return nil, errors.New("Not an aiff file: " + path)
+	}
+
+	var comm, ssnd []byte
+
+	err = forEachChunk(data[12:], binary.BigEndian,
+		func(id string, body []byte) {
+			switch id {
+			case "COMM":
+				comm = body
+			case "SSND":
+				ssnd = body
+			}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(comm) < 18 || len(ssnd) < 8 {
+		return nil, errors.New("Missing COMM or SSND chunk: " + path)
+	}
+
+	be := binary.BigEndian
+	nChans := int(be.Uint16(comm[0:2]))
+	nFrames := int(be.Uint32(comm[2:6]))
+	bits := int(be.Uint16(comm[6:8]))
+	rate := int(extendedToFloat64(comm[8:18]) + 0.5)
+
+	float := false
+	bigEndian := true
+
+	if isAifc {
+		if len(comm) < 22 {
+			return nil, errors.New("Malformed AIFF-C header: " + path)
+		}
+		switch string(comm[18:22]) {
+		case "NONE", "twos":
+		case "sowt":
+			bigEndian = false
+		case "fl32", "FL32":
+			float = true
+			bits = 32
+		case "fl64", "FL64":
+			float = true
+			bits = 64
+		default:
+			return nil, errors.New(
+				"Unsupported AIFF-C compression: " + string(comm[18:22]))
+		}
+	}
+
+	offset := int(be.Uint32(ssnd[0:4]))
+	if 8+offset > len(ssnd) || nChans < 1 {
+		return nil, errors.New("Malformed SSND chunk: " + path)
+	}
+
+	size := (bits + 7) / 8
+	sampleData := ssnd[8+offset:]
+	if n := nFrames * nChans * size; n < len(sampleData) {
+		sampleData = sampleData[:n]
+	}
+
+	chans, err := decodePcm(sampleData, nChans, size, float, bigEndian)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PcmData{Rate: rate, Bits: bits, Chans: chans}, nil
+}
+
+// extendedToFloat64: Convert an 80-bit IEEE 754 extended float.
+func extendedToFloat64(b []byte) float64 {
+	exp := int(binary.BigEndian.Uint16(b[0:2]))
+	mant := binary.BigEndian.Uint64(b[2:10])
+
+	sign := 1.0
+	if exp&0x8000 != 0 {
+		sign = -1
+		exp &= 0x7fff
+	}
+
+	if exp == 0 && mant == 0 {
+		return 0
+	}
+
+	return sign * math.Ldexp(float64(mant), exp-16383-63)
+}
@@ -1,6 +1,7 @@
 package jlsampler
 
 import (
+	"flag"
 	"os"
 	"runtime"
 )
@@ -11,19 +12,70 @@ func RunApp() {
 	Println("JLSampler", version)
 
 	if len(os.Args) < 2 {
-		Println("Usage:", os.Args[0], "sampler-path", "[name]")
+		Println("Usage:", os.Args[0], "[-audio jack|null|file] [-out file]",
+			"[-rate N] [-midi source]", "sampler-path", "[name]")
+		Println("      ", os.Args[0], "render", "[-rate N] [-block N]",
+			"sampler-path", "in.mid", "out.wav")
+		Println("      ", os.Args[0], "measure", "[-rate N] [-low Hz] [-high Hz]")
 		return
 	}
 
-	path := os.Args[1]
+	if os.Args[1] == "render" {
+		runtime.GOMAXPROCS(runtime.NumCPU())
+		RunRender(os.Args[2:])
+		return
+	}
+
+	if os.Args[1] == "measure" {
+		RunMeasure(os.Args[2:])
+		return
+	}
+
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	audio := flags.String("audio", "", "Audio backend: jack, null or file.")
+	audioFile := flags.String("out", "", "Output file for the file backend.")
+	rate := flags.Int("rate", 0, "Sample rate for the null and file backends.")
+	midi := flags.String("midi", "", "Midi source: alsa, raw:<path> or replay:<path>.")
+
+	if err = flags.Parse(os.Args[1:]); err != nil {
+		return
+	}
+
+	if flags.NArg() < 1 {
+		Println("Usage:", os.Args[0], "[-audio jack|null|file] [-out file]",
+			"[-rate N] [-midi source]", "sampler-path", "[name]")
+		return
+	}
+
+	path := flags.Arg(0)
 
 	name := "JLSampler"
-	if len(os.Args) > 2 {
-		name = os.Args[2]
+	if flags.NArg() > 1 {
+		name = flags.Arg(1)
+	}
+
+	// Try to load config. Command line flags take precedence.
+	config, err := LoadConfig()
+	if err != nil {
+		Println("Error:", err)
+		return
+	}
+
+	if len(*audio) != 0 {
+		config.Audio = *audio
+	}
+	if len(*audioFile) != 0 {
+		config.AudioFile = *audioFile
+	}
+	if *rate != 0 {
+		config.SampleRate = *rate
+	}
+	if len(*midi) != 0 {
+		config.MidiSource = *midi
 	}
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
-	sampler, err := NewSampler(name, path)
+	sampler, err := NewSampler(name, path, config)
 	if err != nil {
 		Println("Error:", err)
 		return
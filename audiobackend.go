@@ -0,0 +1,49 @@
+package jlsampler
+
+import (
+	"errors"
+)
+
+// ----------------------------------------------------------------------------
+// ProcessFunc: An audio processing callback. bufOut holds one slice per
+// output channel, each the length of the current period.
+type ProcessFunc func(bufIn, bufOut [][]float32) error
+
+// AudioBackend: An audio output driving the sampler's processing callback.
+type AudioBackend interface {
+	// Open: Open the backend with the given client name and number of input
+	// and output channels.
+	Open(name string, nIn, nOut int) error
+
+	// SampleRate: The output sample rate. Only valid after Open.
+	SampleRate() int
+
+	// RegisterCallback: Start calling fn for each period.
+	RegisterCallback(fn ProcessFunc)
+
+	// Close: Stop calling the callback and release the backend.
+	Close() error
+}
+
+// NewAudioBackend: Create the backend selected by config.Audio. One of
+// "jack" (the default), "null" or "file".
+func NewAudioBackend(config *Config) (AudioBackend, error) {
+	rate := config.SampleRate
+	if rate <= 0 {
+		rate = sampleRate
+	}
+
+	switch config.Audio {
+	case "", "jack":
+		return NewJackBackend(), nil
+	case "null":
+		return NewNullBackend(rate), nil
+	case "file":
+		if len(config.AudioFile) == 0 {
+			return nil, errors.New("No output file given for file backend.")
+		}
+		return NewFileBackend(rate, config.AudioFile), nil
+	}
+
+	return nil, errors.New("Unknown audio backend: " + config.Audio)
+}
@@ -0,0 +1,155 @@
+package jlsampler
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// PcmData: Decoded audio from a wav or aiff file. Samples are scaled to the
+// range -1 to 1.
+type PcmData struct {
+	Rate  int         // Sample rate.
+	Bits  int         // Bits per sample in the file.
+	Chans [][]float32 // Samples for each channel.
+	Loop  *SampleLoop // Sustain loop, if the file has one.
+}
+
+// sampleExts: File extensions of loadable samples.
+var sampleExts = map[string]bool{
+	".flac": true,
+	".wav":  true,
+	".aif":  true,
+	".aiff": true,
+	".aifc": true,
+}
+
+func isSampleFile(path string) bool {
+	return sampleExts[strings.ToLower(filepath.Ext(path))]
+}
+
+// LoadSampleFile: Load a sample, choosing the decoder by file extension.
+// If bits16 is true, the sample is stored in 16 bits to save memory.
+func LoadSampleFile(path string, bits16 bool) (*Sample, error) {
+	var pcm *PcmData
+	var err error
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".flac":
+		pcm, err = LoadFlac(path)
+	case ".wav":
+		pcm, err = LoadWav(path)
+	case ".aif", ".aiff", ".aifc":
+		pcm, err = LoadAiff(path)
+	default:
+		return nil, errors.New("Unknown sample file type: " + path)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSampleFromPcm(pcm, bits16)
+}
+
+// NewSampleFromPcm: Create a sample from mono or stereo pcm data. Mono data
+// is stored once.
+func NewSampleFromPcm(pcm *PcmData, bits16 bool) (*Sample, error) {
+	if len(pcm.Chans) < 1 || len(pcm.Chans) > 2 {
+		return nil, errors.New("Only mono and stereo samples are supported.")
+	}
+
+	L := pcm.Chans[0]
+	var R []float32
+	if len(pcm.Chans) == 2 {
+		R = pcm.Chans[1]
+	}
+
+	var s *Sample
+	if bits16 {
+		var R16 []int16
+		if R != nil {
+			R16 = pcmToInt16(R)
+		}
+		s = NewSampleFromArrays16(pcmToInt16(L), R16)
+	} else {
+		s = NewSampleFromArrays(L, R, pcm.Bits)
+	}
+
+	if pcm.Rate > 0 {
+		s.Rate = pcm.Rate
+	}
+
+	if pcm.Loop != nil && pcm.Loop.valid(s.Len) {
+		s.Loop = pcm.Loop
+	}
+
+	return s, nil
+}
+
+func pcmToInt16(x []float32) []int16 {
+	y := make([]int16, len(x))
+	for i, v := range x {
+		y[i] = toInt16(v)
+	}
+	return y
+}
+
+// ----------------------------------------------------------------------------
+// decodePcm: Decode interleaved integer or float samples.
+// size is the number of bytes per sample.
+func decodePcm(data []byte, nChans, size int, float, bigEndian bool) (
+	[][]float32, error) {
+
+	if nChans < 1 || size < 1 || size > 8 {
+		return nil, errors.New("Unsupported sample format.")
+	}
+	if float && size != 4 && size != 8 {
+		return nil, errors.New("Unsupported float sample size.")
+	}
+
+	nFrames := len(data) / (nChans * size)
+	chans := make([][]float32, nChans)
+	for i := range chans {
+		chans[i] = make([]float32, nFrames)
+	}
+
+	scale := 1.0 / float64(uint64(1)<<uint(8*size-1))
+
+	pos := 0
+	for i := 0; i < nFrames; i++ {
+		for c := 0; c < nChans; c++ {
+			b := data[pos : pos+size]
+			pos += size
+
+			// Assemble the bytes big-endian first.
+			var u uint64
+			for j := 0; j < size; j++ {
+				if bigEndian {
+					u = u<<8 | uint64(b[j])
+				} else {
+					u = u<<8 | uint64(b[size-1-j])
+				}
+			}
+
+			switch {
+			case float && size == 4:
+				chans[c][i] = math.Float32frombits(uint32(u))
+			case float:
+				chans[c][i] = float32(math.Float64frombits(u))
+			case size == 1 && !bigEndian:
+				// 8-bit wav data is unsigned.
+				chans[c][i] = float32(float64(int(u)-128) * scale)
+			default:
+				// Sign extend.
+				shift := uint(64 - 8*size)
+				v := int64(u<<shift) >> shift
+				chans[c][i] = float32(float64(v) * scale)
+			}
+		}
+	}
+
+	return chans, nil
+}
@@ -8,7 +8,11 @@ import (
 )
 
 type Config struct {
-	MidiIn string // Controller midi port (keyboard).
+	MidiIn     string // Controller midi ports (client:port or name, comma separated).
+	MidiSource string // Midi source: "alsa" (default), "raw:<path>" or "replay:<path>".
+	Audio      string // Audio backend: "jack" (default), "null" or "file".
+	AudioFile  string // Output file for the file backend.
+	SampleRate int    // Sample rate for the null and file backends.
 }
 
 func LoadConfig() (*Config, error) {
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"math"
 	"os"
 	"os/user"
@@ -27,13 +28,57 @@ type Controls struct {
 	sampler *Sampler // For callbacks.
 	NFadeIn float32  // Fade-in length in samples. Computed from TauFadeIn.
 
+	NLoopXfade float32 // Loop crossfade in samples. Computed from LoopXfade.
+	outRate    float64 // Output sample rate. Set by the sampler.
+	pedal      float64 // Sustain pedal position from 0 to 1.
+	pedalLift  float64 // How far a half pedal lifts the dampers, from 0 to 1.
+
+	tunings []*Tuning // Loaded from Tunings.
+	mts     MtsTuning // Set by MTS messages. Cleared by MtsReset.
+
 	Transpose     int8 // Added to midi note on input.
 	PitchBendMax  int8 // Maximum pitch bend in semitones.
 	RRBorrow      int8 // Distance to borrow round-robbin samples.
+	Interpolation int8 // Interpolation kernel. See InterpKernel.
 
 	Tau       float64 // Key-up decay time constant.
 	TauCut    float64 // Key-repeat or cut decay time constant.
 	TauFadeIn float64 // Sample fade in time.
+	TauSteal  float64 // Decay time constant for stolen voices.
+
+	MaxVoices int  // Maximum number of voices. 0 is unlimited.
+	StealMode int8 // Voice stealing policy. See StealOldest, etc.
+
+	// The envelope replaces Tau when Envelope is true. Times are in seconds.
+	Envelope     bool    // Use the ADSR envelope.
+	EnvCurve     int8    // Envelope curve. See CurveLinear, etc.
+	EnvAttack    float64 // Attack time.
+	EnvHold      float64 // Hold time at full level.
+	EnvDecay     float64 // Decay time to the sustain level.
+	EnvSustain   float64 // Sustain level from 0 to 1.
+	EnvRelease   float64 // Release time.
+	EnvVelAttack float64 // Attack is scaled by 1 - EnvVelAttack*velocity.
+	EnvKeyDecay  float64 // Decay and release scale by octave from key 60.
+
+	// Per-voice filter. The cutoff is in Hz, and the tracking and envelope
+	// amounts are in octaves.
+	FilterType       int8    // Filter type. See FilterLowPass, etc.
+	Filter24dB       bool    // Use a 24 dB/octave slope instead of 12.
+	FilterCutoff     float64 // Cutoff at full velocity for key 60.
+	FilterQ          float64 // Resonance. 0.707 is flat.
+	FilterVel        float64 // Cutoff shift at zero velocity, negated.
+	FilterKey        float64 // Cutoff shift per octave from key 60.
+	FilterEnvAmt     float64 // Cutoff shift at full envelope level.
+	FilterEnvAttack  float64 // Filter envelope times in seconds.
+	FilterEnvDecay   float64
+	FilterEnvSustain float64 // Filter envelope sustain level from 0 to 1.
+	FilterEnvRelease float64
+
+	LoopMode  int8    // Loop mode for all samples. -1 uses each sample's mode.
+	LoopXfade float64 // Loop crossfade time in seconds.
+
+	ReleaseAmp      float64 // Release sample amplification. 0 disables.
+	ReleaseHoldTime float64 // Release level falls by 1/e per this hold time.
 
 	Amp        float64 // Amplification multiplier. 
 	CropThresh float64 // Cut beginning of samples below this threshold.
@@ -45,16 +90,71 @@ type Controls struct {
 	GammaAmp   float64 // Amplitude scaling x^gamma.
 	GammaLayer float64 // Layer scaling.
 	VelMult    float64 // Velocity multiplier.
+	Width      float64 // Stereo width multiplier. 0 is mono, 1 is unchanged.
 
 	MixLayers   bool // It True, mix layers together.
 	FakeLayerRC bool // Use RC filter to construct fake zero-layer. 
-	Sustain     bool // Sustain pedal value (0-1).
+	Bits16      bool // Store samples in 16 bits to save memory.
+	Resample    bool // Resample samples to the output rate when loading.
+	Sustain     bool // True if the sustain pedal lifts the dampers.
+	Sostenuto   bool // True while the sostenuto pedal is down.
+	Soft        bool // True while the soft pedal is down.
+
+	// The dampers start to lift at HalfPedalLow and are clear of the
+	// strings at HalfPedalHigh. In between, released keys decay more slowly
+	// than their release. Pedal noise is scaled down for pedal speeds (in pedal
+	// range per second) below PedalNoiseSpeed.
+	HalfPedalLow    float64
+	HalfPedalHigh   float64
+	PedalNoiseAmp   float64 // Pedal noise amplification. 0 disables.
+	PedalNoiseSpeed float64 // Pedal speed for full noise level.
+
+	SoftVelocity float64 // Velocity multiplier while the soft pedal is down.
+	SoftFilter   float64 // Filter cutoff shift down in octaves when soft.
+
+	// Sympathetic resonance of undamped strings. The gain is interpolated
+	// between ResonanceLow at key 21 and ResonanceHigh at key 108.
+	Resonance     float64 // Resonance amount. 0 disables.
+	ResonanceTime float64 // Ring time constant in seconds.
+	ResonanceLow  float64
+	ResonanceHigh float64
+
+	// Convolution reverb. The impulse response file is looked up in the
+	// sample-set directory, then in ~/.jlsampler.
+	ReverbFile     string  // Impulse response file. Empty disables.
+	ReverbWet      float64 // Wet level.
+	ReverbDry      float64 // Dry level.
+	ReverbPredelay float64 // Predelay in seconds.
+
+	// The master limiter keeps true peaks below LimiterThresh. The soft
+	// clipper uses the same threshold as its ceiling. Both are off by
+	// default, and can be enabled in defaults.js.
+	Limiter          bool    // Enable the limiter.
+	LimiterThresh    float64 // Threshold in dBFS.
+	LimiterRelease   float64 // Release time constant in seconds.
+	LimiterLookahead float64 // Lookahead in seconds. Adds latency.
+	SoftClip         bool    // Enable the soft clipper.
+
+	// Master EQ bands. See EqBands. Bands are numbered from 1 in control
+	// names, e.g. Eq1Gain, Eq1Freq and Eq1Q.
+	Eq EqBands
+
+	Outputs []OutputPair // Stereo output pairs. See OutputPair.
+
+	// Scala tunings. Tuning 0 is equal temperament, and 1 and up select
+	// from Tunings. Program changes select the tuning if they're bound to
+	// it in controls.js.
+	Tunings []TuningSpec
+	Tuning  int
 
 	// A map from control name to update function.
 	updateMap map[string]func(float64)
 
 	// Bindings for midi controls.
 	midiControls []func(float64)
+
+	// Binding for program changes, or nil. Called with the program number.
+	programControl func(float64)
 }
 
 func NewControls(sampler *Sampler) *Controls {
@@ -63,9 +163,37 @@ func NewControls(sampler *Sampler) *Controls {
 	c.Transpose = 0
 	c.PitchBendMax = 1
 	c.RRBorrow = 0
+	c.Interpolation = int8(KernelLinear)
 	c.Tau = 0
 	c.TauCut = 0
 	c.TauFadeIn = 0
+	c.TauSteal = 0.005
+	c.MaxVoices = 0
+	c.StealMode = StealOldest
+	c.Envelope = false
+	c.EnvCurve = CurveExponential
+	c.EnvAttack = 0
+	c.EnvHold = 0
+	c.EnvDecay = 0
+	c.EnvSustain = 1.0
+	c.EnvRelease = 0.3
+	c.EnvVelAttack = 0
+	c.EnvKeyDecay = 0
+	c.FilterType = FilterOff
+	c.Filter24dB = false
+	c.FilterCutoff = 20000
+	c.FilterQ = 0.707
+	c.FilterVel = 0
+	c.FilterKey = 0
+	c.FilterEnvAmt = 0
+	c.FilterEnvAttack = 0
+	c.FilterEnvDecay = 0
+	c.FilterEnvSustain = 1.0
+	c.FilterEnvRelease = 0.3
+	c.LoopMode = -1
+	c.LoopXfade = 0.01
+	c.ReleaseAmp = 1.0
+	c.ReleaseHoldTime = 0
 	c.Amp = 1.0
 	c.CropThresh = 0
 	c.RmsTime = 0.25
@@ -76,28 +204,112 @@ func NewControls(sampler *Sampler) *Controls {
 	c.GammaAmp = 2.2
 	c.GammaLayer = 1.0
 	c.VelMult = 1.0
+	c.Width = 1.0
 	c.MixLayers = false
 	c.FakeLayerRC = false
+	c.Bits16 = false
+	c.Resample = false
 	c.Sustain = false
+	c.Sostenuto = false
+	c.Soft = false
+	c.HalfPedalLow = 0.5
+	c.HalfPedalHigh = 0.5
+	c.PedalNoiseAmp = 1.0
+	c.PedalNoiseSpeed = 10
+	c.SoftVelocity = 1.0
+	c.SoftFilter = 0
+	c.Resonance = 0
+	c.ResonanceTime = 2
+	c.ResonanceLow = 1
+	c.ResonanceHigh = 1
+	c.ReverbFile = ""
+	c.ReverbWet = 0.3
+	c.ReverbDry = 1
+	c.ReverbPredelay = 0
+	c.Limiter = false
+	c.LimiterThresh = -1
+	c.LimiterRelease = 0.1
+	c.LimiterLookahead = 0.002
+	c.SoftClip = false
+	c.Eq = defaultEqBands()
 
 	c.updateMap = map[string]func(float64){
-		"Transpose":    c.UpdateTranspose,
-		"PitchBendMax": c.UpdatePitchBendMax,
-		"Tau":          c.UpdateTau,
-		"TauCut":       c.UpdateTauCut,
-		"TauFadeIn":    c.UpdateTauFadeIn,
-		"Amp":          c.UpdateAmp,
-		"CropThresh":   c.UpdateCropThresh,
-		"RmsTime":      c.UpdateRmsTime,
-		"RmsLow":       c.UpdateRmsLow,
-		"RmsHigh":      c.UpdateRmsHigh,
-		"PanLow":       c.UpdatePanLow,
-		"PanHigh":      c.UpdatePanHigh,
-		"GammaAmp":     c.UpdateGammaAmp,
-		"GammaLayer":   c.UpdateGammaLayer,
-		"VelMult":      c.UpdateVelMult,
-		"MixLayers":    c.UpdateMixLayers,
-		"Sustain":      c.UpdateSustain,
+		"Transpose":        c.UpdateTranspose,
+		"PitchBendMax":     c.UpdatePitchBendMax,
+		"Interpolation":    c.UpdateInterpolation,
+		"Tau":              c.UpdateTau,
+		"TauCut":           c.UpdateTauCut,
+		"TauFadeIn":        c.UpdateTauFadeIn,
+		"TauSteal":         c.UpdateTauSteal,
+		"MaxVoices":        c.UpdateMaxVoices,
+		"StealMode":        c.UpdateStealMode,
+		"Envelope":         c.UpdateEnvelope,
+		"EnvCurve":         c.UpdateEnvCurve,
+		"EnvAttack":        c.UpdateEnvAttack,
+		"EnvHold":          c.UpdateEnvHold,
+		"EnvDecay":         c.UpdateEnvDecay,
+		"EnvSustain":       c.UpdateEnvSustain,
+		"EnvRelease":       c.UpdateEnvRelease,
+		"EnvVelAttack":     c.UpdateEnvVelAttack,
+		"EnvKeyDecay":      c.UpdateEnvKeyDecay,
+		"FilterType":       c.UpdateFilterType,
+		"Filter24dB":       c.UpdateFilter24dB,
+		"FilterCutoff":     c.UpdateFilterCutoff,
+		"FilterQ":          c.UpdateFilterQ,
+		"FilterVel":        c.UpdateFilterVel,
+		"FilterKey":        c.UpdateFilterKey,
+		"FilterEnvAmt":     c.UpdateFilterEnvAmt,
+		"FilterEnvAttack":  c.UpdateFilterEnvAttack,
+		"FilterEnvDecay":   c.UpdateFilterEnvDecay,
+		"FilterEnvSustain": c.UpdateFilterEnvSustain,
+		"FilterEnvRelease": c.UpdateFilterEnvRelease,
+		"LoopMode":         c.UpdateLoopMode,
+		"LoopXfade":        c.UpdateLoopXfade,
+		"ReleaseAmp":       c.UpdateReleaseAmp,
+		"ReleaseHoldTime":  c.UpdateReleaseHoldTime,
+		"Amp":              c.UpdateAmp,
+		"CropThresh":       c.UpdateCropThresh,
+		"RmsTime":          c.UpdateRmsTime,
+		"RmsLow":           c.UpdateRmsLow,
+		"RmsHigh":          c.UpdateRmsHigh,
+		"PanLow":           c.UpdatePanLow,
+		"PanHigh":          c.UpdatePanHigh,
+		"GammaAmp":         c.UpdateGammaAmp,
+		"GammaLayer":       c.UpdateGammaLayer,
+		"VelMult":          c.UpdateVelMult,
+		"Width":            c.UpdateWidth,
+		"MixLayers":        c.UpdateMixLayers,
+		"Sostenuto":        c.UpdateSostenuto,
+		"Soft":             c.UpdateSoft,
+		"HalfPedalLow":     c.UpdateHalfPedalLow,
+		"HalfPedalHigh":    c.UpdateHalfPedalHigh,
+		"PedalNoiseAmp":    c.UpdatePedalNoiseAmp,
+		"PedalNoiseSpeed":  c.UpdatePedalNoiseSpeed,
+		"SoftVelocity":     c.UpdateSoftVelocity,
+		"SoftFilter":       c.UpdateSoftFilter,
+		"Resonance":        c.UpdateResonance,
+		"ResonanceTime":    c.UpdateResonanceTime,
+		"ResonanceLow":     c.UpdateResonanceLow,
+		"ResonanceHigh":    c.UpdateResonanceHigh,
+		"ReverbWet":        c.UpdateReverbWet,
+		"ReverbDry":        c.UpdateReverbDry,
+		"ReverbPredelay":   c.UpdateReverbPredelay,
+		"Limiter":          c.UpdateLimiter,
+		"LimiterThresh":    c.UpdateLimiterThresh,
+		"LimiterRelease":   c.UpdateLimiterRelease,
+		"LimiterLookahead": c.UpdateLimiterLookahead,
+		"Tuning":           c.UpdateTuning,
+		"MtsReset":         c.UpdateMtsReset,
+		"SoftClip":         c.UpdateSoftClip,
+		"Sustain":          c.UpdateSustain,
+	}
+
+	for i := range c.Eq {
+		i := i
+		n := "Eq" + strconv.Itoa(i+1)
+		c.updateMap[n+"Gain"] = func(x float64) { c.UpdateEqGain(i, x) }
+		c.updateMap[n+"Freq"] = func(x float64) { c.UpdateEqFreq(i, x) }
+		c.updateMap[n+"Q"] = func(x float64) { c.UpdateEqQ(i, x) }
 	}
 
 	c.midiControls = make([]func(float64), 128)
@@ -126,16 +338,21 @@ func (c *Controls) LoadFrom(path string) error {
 	c.UpdateTau(c.Tau)
 	c.UpdateTauCut(c.TauCut)
 	c.UpdateTauFadeIn(c.TauFadeIn)
+	c.UpdateTauSteal(c.TauSteal)
+	c.UpdateLoopXfade(c.LoopXfade)
 
 	return nil
 }
 
+// ctrlCfg: A binding in controls.js. If Program is set, program changes
+// set the control to the program number, and the other fields are ignored.
 type ctrlCfg struct {
-	Name  string
-	Num   int8
-	Min   float64
-	Max   float64
-	Gamma float64
+	Name    string
+	Num     int8
+	Min     float64
+	Max     float64
+	Gamma   float64
+	Program bool
 }
 
 func (c *Controls) LoadMidiConfig() error {
@@ -161,7 +378,11 @@ func (c *Controls) LoadMidiConfig() error {
 
 	// Load configs.
 	for _, cfg := range configs {
-		err = c.bind(cfg.Name, cfg.Num, cfg.Min, cfg.Max, cfg.Gamma)
+		if cfg.Program {
+			err = c.bindProgram(cfg.Name)
+		} else {
+			err = c.bind(cfg.Name, cfg.Num, cfg.Min, cfg.Max, cfg.Gamma)
+		}
 		if err != nil {
 			return err
 		}
@@ -187,6 +408,15 @@ func (c *Controls) bind(name string, num int8, min, max, gamma float64) error {
 	return nil
 }
 
+func (c *Controls) bindProgram(name string) error {
+	fn, ok := c.updateMap[name]
+	if !ok {
+		return errors.New("Unknown control: " + name)
+	}
+	c.programControl = fn
+	return nil
+}
+
 func (c *Controls) Print() {
 	Println("--------------------------------------------------")
 	Println("Transpose:    ", c.Transpose)
@@ -194,6 +424,48 @@ func (c *Controls) Print() {
 	Println("Tau:          ", -1/(math.Log(c.Tau)*sampleRate))
 	Println("TauCut:       ", -1/(math.Log(c.TauCut)*sampleRate))
 	Println("TauFadeIn:    ", -1/(math.Log(c.TauFadeIn)*sampleRate))
+	Println("TauSteal:     ", -1/(math.Log(c.TauSteal)*sampleRate))
+	Println("MaxVoices:    ", c.MaxVoices)
+	Println("StealMode:    ", stealModeName(c.StealMode))
+	Println("Envelope:     ", c.Envelope)
+	Println("EnvCurve:     ", curveName(c.EnvCurve))
+	Println("EnvAttack:    ", c.EnvAttack)
+	Println("EnvHold:      ", c.EnvHold)
+	Println("EnvDecay:     ", c.EnvDecay)
+	Println("EnvSustain:   ", c.EnvSustain)
+	Println("EnvRelease:   ", c.EnvRelease)
+	Println("EnvVelAttack: ", c.EnvVelAttack)
+	Println("EnvKeyDecay:  ", c.EnvKeyDecay)
+	Println("FilterType:   ", filterName(c.FilterType))
+	Println("Filter24dB:   ", c.Filter24dB)
+	Println("FilterCutoff: ", c.FilterCutoff)
+	Println("FilterQ:      ", c.FilterQ)
+	Println("FilterVel:    ", c.FilterVel)
+	Println("FilterKey:    ", c.FilterKey)
+	Println("FilterEnvAmt: ", c.FilterEnvAmt)
+	Println("FilterEnv:    ", c.FilterEnvAttack, c.FilterEnvDecay,
+		c.FilterEnvSustain, c.FilterEnvRelease)
+	Println("HalfPedal:    ", c.HalfPedalLow, c.HalfPedalHigh)
+	Println("PedalNoise:   ", c.PedalNoiseAmp, c.PedalNoiseSpeed)
+	Println("Resonance:    ", c.Resonance, c.ResonanceTime,
+		c.ResonanceLow, c.ResonanceHigh)
+	Println("Reverb:       ", c.ReverbFile, c.ReverbWet, c.ReverbDry,
+		c.ReverbPredelay)
+	Println("Limiter:      ", c.Limiter, c.LimiterThresh, c.LimiterRelease,
+		c.LimiterLookahead)
+	Println("SoftClip:     ", c.SoftClip)
+	Println("Outputs:      ", c.numOutputs())
+	Println("Tuning:       ", c.Tuning, c.tuningName())
+	for i, b := range c.Eq {
+		Println(fmt.Sprintf("Eq%d:          ", i+1),
+			eqTypeName(b.Type), b.Freq, b.Gain, b.Q)
+	}
+	Println("SoftVelocity: ", c.SoftVelocity)
+	Println("SoftFilter:   ", c.SoftFilter)
+	Println("LoopMode:     ", loopModeName(c.LoopMode))
+	Println("LoopXfade:    ", c.LoopXfade)
+	Println("ReleaseAmp:   ", c.ReleaseAmp)
+	Println("ReleaseHoldTime:", c.ReleaseHoldTime)
 	Println("Amp:          ", c.Amp)
 	Println("CropThresh:   ", c.CropThresh)
 	Println("RmsTime:      ", c.RmsTime)
@@ -204,9 +476,15 @@ func (c *Controls) Print() {
 	Println("GammaAmp:     ", c.GammaAmp)
 	Println("GammaLayer:   ", c.GammaLayer)
 	Println("VelMult:      ", c.VelMult)
+	Println("Width:        ", c.Width)
 	Println("PitchBendMax: ", c.PitchBendMax)
+	Println("Interpolation:", InterpKernel(c.Interpolation))
 	Println("MixLayers:    ", c.MixLayers)
 	Println("FakeLayerRC:  ", c.FakeLayerRC)
+	Println("Bits16:       ", c.Bits16)
+	Println("Resample:     ", c.Resample)
+	Println("Stolen voices:", c.sampler.StolenVoices())
+	Println("Gain reduction:", c.sampler.GainReduction(), "dB")
 }
 
 func (c *Controls) CalcAmp(key int, velocity, rms float64) float32 {
@@ -219,11 +497,53 @@ func (c *Controls) CalcAmp(key int, velocity, rms float64) float32 {
 	return float32(amp * math.Pow(velocity, c.GammaAmp))
 }
 
+// filterEnvParams: The filter envelope. It uses the amplitude envelope's
+// curve.
+func (c *Controls) filterEnvParams() EnvParams {
+	return EnvParams{
+		Curve:   c.EnvCurve,
+		Attack:  c.FilterEnvAttack,
+		Decay:   c.FilterEnvDecay,
+		Sustain: c.FilterEnvSustain,
+		Release: c.FilterEnvRelease,
+	}
+}
+
 func (c *Controls) CalcPan(key int) float32 {
 	m := (c.PanHigh - c.PanLow) / 87.0
 	return float32(c.PanLow + m*(float64(key)-21))
 }
 
+// tuningName: The name of the current tuning.
+func (c *Controls) tuningName() string {
+	name := "equal temperament"
+	if c.Tuning >= 1 && c.Tuning <= len(c.tunings) {
+		name = c.tunings[c.Tuning-1].Name
+	}
+	if c.mts.active() {
+		name += " + MTS"
+	}
+	return name
+}
+
+// tuningOffset: The pitch offset of the key in semitones in the current
+// tuning. Keys set by MTS messages override the selected tuning. MTS tunes
+// the midi notes as received, so it's indexed by the note before Transpose.
+func (c *Controls) tuningOffset(key int) float64 {
+	if offset, ok := c.mts.Offset(key - int(c.Transpose)); ok {
+		return offset
+	}
+	if c.Tuning < 1 || c.Tuning > len(c.tunings) {
+		return 0
+	}
+	return c.tunings[c.Tuning-1].Offset(key)
+}
+
+func (c *Controls) CalcResonance(key int) float32 {
+	m := (c.ResonanceHigh - c.ResonanceLow) / 87.0
+	return float32(c.ResonanceLow + m*(float64(key)-21))
+}
+
 func (c *Controls) Run() {
 	reader := bufio.NewReader(os.Stdin)
 
@@ -269,7 +589,7 @@ func (c *Controls) ProcessCommand(cmd string) {
 		return
 	}
 
-	f(val)
+	c.sampler.ControlEvent(f, val)
 }
 
 func (c *Controls) ProcessMidi(num int32, value float64) {
@@ -291,6 +611,7 @@ func (c *Controls) UpdateTranspose(x float64) {
 
 func (c *Controls) UpdateTau(x float64) {
 	c.Tau = computeTau(x)
+	c.updatePedalLift()
 	Println("Tau:", x)
 }
 
@@ -305,6 +626,164 @@ func (c *Controls) UpdateTauFadeIn(x float64) {
 	Println("TauFadeIn:", x)
 }
 
+func (c *Controls) UpdateTauSteal(x float64) {
+	c.TauSteal = computeTau(x)
+	Println("TauSteal:", x)
+}
+
+func (c *Controls) UpdateMaxVoices(x float64) {
+	c.MaxVoices = int(x)
+	Println("MaxVoices:", c.MaxVoices)
+}
+
+func (c *Controls) UpdateStealMode(x float64) {
+	mode := int8(x)
+	if mode < 0 || mode >= numStealModes {
+		Println("StealMode out of range:", x)
+		return
+	}
+	c.StealMode = mode
+	Println("StealMode:", stealModeName(mode))
+}
+
+func (c *Controls) UpdateEnvelope(x float64) {
+	c.Envelope = x > 0.5
+	Println("Envelope:", c.Envelope)
+}
+
+func (c *Controls) UpdateEnvCurve(x float64) {
+	curve := int8(x)
+	if curve < 0 || curve >= numCurves {
+		Println("EnvCurve out of range:", x)
+		return
+	}
+	c.EnvCurve = curve
+	Println("EnvCurve:", curveName(curve))
+}
+
+func (c *Controls) UpdateEnvAttack(x float64) {
+	c.EnvAttack = x
+	Println("EnvAttack:", x)
+}
+
+func (c *Controls) UpdateEnvHold(x float64) {
+	c.EnvHold = x
+	Println("EnvHold:", x)
+}
+
+func (c *Controls) UpdateEnvDecay(x float64) {
+	c.EnvDecay = x
+	Println("EnvDecay:", x)
+}
+
+func (c *Controls) UpdateEnvSustain(x float64) {
+	c.EnvSustain = x
+	Println("EnvSustain:", x)
+}
+
+func (c *Controls) UpdateEnvRelease(x float64) {
+	c.EnvRelease = x
+	Println("EnvRelease:", x)
+}
+
+func (c *Controls) UpdateEnvVelAttack(x float64) {
+	c.EnvVelAttack = x
+	Println("EnvVelAttack:", x)
+}
+
+func (c *Controls) UpdateEnvKeyDecay(x float64) {
+	c.EnvKeyDecay = x
+	Println("EnvKeyDecay:", x)
+}
+
+func (c *Controls) UpdateFilterType(x float64) {
+	typ := int8(x)
+	if typ < 0 || typ >= numFilterTypes {
+		Println("FilterType out of range:", x)
+		return
+	}
+	c.FilterType = typ
+	Println("FilterType:", filterName(typ))
+}
+
+func (c *Controls) UpdateFilter24dB(x float64) {
+	c.Filter24dB = x > 0.5
+	Println("Filter24dB:", c.Filter24dB)
+}
+
+func (c *Controls) UpdateFilterCutoff(x float64) {
+	c.FilterCutoff = x
+	Println("FilterCutoff:", x)
+}
+
+func (c *Controls) UpdateFilterQ(x float64) {
+	c.FilterQ = x
+	Println("FilterQ:", x)
+}
+
+func (c *Controls) UpdateFilterVel(x float64) {
+	c.FilterVel = x
+	Println("FilterVel:", x)
+}
+
+func (c *Controls) UpdateFilterKey(x float64) {
+	c.FilterKey = x
+	Println("FilterKey:", x)
+}
+
+func (c *Controls) UpdateFilterEnvAmt(x float64) {
+	c.FilterEnvAmt = x
+	Println("FilterEnvAmt:", x)
+}
+
+func (c *Controls) UpdateFilterEnvAttack(x float64) {
+	c.FilterEnvAttack = x
+	Println("FilterEnvAttack:", x)
+}
+
+func (c *Controls) UpdateFilterEnvDecay(x float64) {
+	c.FilterEnvDecay = x
+	Println("FilterEnvDecay:", x)
+}
+
+func (c *Controls) UpdateFilterEnvSustain(x float64) {
+	c.FilterEnvSustain = x
+	Println("FilterEnvSustain:", x)
+}
+
+func (c *Controls) UpdateFilterEnvRelease(x float64) {
+	c.FilterEnvRelease = x
+	Println("FilterEnvRelease:", x)
+}
+
+func (c *Controls) UpdateLoopMode(x float64) {
+	mode := int8(x)
+	if mode < -1 || mode >= numLoopModes {
+		Println("LoopMode out of range:", x)
+		return
+	}
+	c.LoopMode = mode
+	Println("LoopMode:", loopModeName(mode))
+}
+
+func (c *Controls) UpdateLoopXfade(x float64) {
+	c.LoopXfade = x
+	// The crossfade is measured in sample frames, which are stored at the
+	// sampler's sample rate rather than the output rate.
+	c.NLoopXfade = float32(x * float64(c.sampler.sampleRate))
+	Println("LoopXfade:", x)
+}
+
+func (c *Controls) UpdateReleaseAmp(x float64) {
+	c.ReleaseAmp = x
+	Println("ReleaseAmp:", x)
+}
+
+func (c *Controls) UpdateReleaseHoldTime(x float64) {
+	c.ReleaseHoldTime = x
+	Println("ReleaseHoldTime:", x)
+}
+
 func (c *Controls) UpdateAmp(x float64) {
 	c.Amp = x
 	Println("Amp:", x)
@@ -313,13 +792,13 @@ func (c *Controls) UpdateAmp(x float64) {
 func (c *Controls) UpdateCropThresh(x float64) {
 	c.CropThresh = x
 	Println("CropThresh:", x)
-	c.sampler.UpdateCropThresh()
+	c.sampler.requestSampleStats()
 }
 
 func (c *Controls) UpdateRmsTime(x float64) {
 	c.RmsTime = x
 	Println("RmsTime:", x)
-	c.sampler.UpdateRms()
+	c.sampler.requestSampleStats()
 }
 
 func (c *Controls) UpdateRmsLow(x float64) {
@@ -357,16 +836,191 @@ func (c *Controls) UpdateVelMult(x float64) {
 	Println("VelMult:", x)
 }
 
+func (c *Controls) UpdateWidth(x float64) {
+	c.Width = x
+	Println("Width:", x)
+}
+
 func (c *Controls) UpdatePitchBendMax(x float64) {
 	c.PitchBendMax = int8(x)
 	Println("PitchBendMax:", c.PitchBendMax)
 }
 
+func (c *Controls) UpdateInterpolation(x float64) {
+	k := InterpKernel(x)
+	if k < 0 || k >= numKernels {
+		Println("Interpolation out of range:", x)
+		return
+	}
+	c.Interpolation = int8(k)
+	Println("Interpolation:", k)
+}
+
 func (c *Controls) UpdateMixLayers(x float64) {
 	c.MixLayers = x > 0.5
 	Println("MixLayers:", c.MixLayers)
 }
 
 func (c *Controls) UpdateSustain(x float64) {
-	c.Sustain = x > 0.5
+	c.pedal = x
+	c.Sustain = x > c.HalfPedalLow
+	c.updatePedalLift()
+	c.sampler.sustainPedal(x)
+}
+
+// updatePedalLift: Compute how far the dampers are lifted for the pedal
+// position, going from 0 to 1 between HalfPedalLow and HalfPedalHigh. Each
+// voice computes its own decay from this. See PlayingSample.halfDamp.
+func (c *Controls) updatePedalLift() {
+	p := 1.0
+	if c.pedal < c.HalfPedalHigh && c.HalfPedalHigh > c.HalfPedalLow {
+		p = (c.pedal - c.HalfPedalLow) / (c.HalfPedalHigh - c.HalfPedalLow)
+	}
+	if p > 1 {
+		p = 1
+	} else if p < 0 {
+		p = 0
+	}
+	c.pedalLift = p
+}
+
+func (c *Controls) UpdateHalfPedalLow(x float64) {
+	c.HalfPedalLow = x
+	c.updatePedalLift()
+	Println("HalfPedalLow:", x)
+}
+
+func (c *Controls) UpdateHalfPedalHigh(x float64) {
+	c.HalfPedalHigh = x
+	c.updatePedalLift()
+	Println("HalfPedalHigh:", x)
+}
+
+func (c *Controls) UpdatePedalNoiseAmp(x float64) {
+	c.PedalNoiseAmp = x
+	Println("PedalNoiseAmp:", x)
+}
+
+func (c *Controls) UpdatePedalNoiseSpeed(x float64) {
+	c.PedalNoiseSpeed = x
+	Println("PedalNoiseSpeed:", x)
+}
+
+func (c *Controls) UpdateSostenuto(x float64) {
+	on := x > 0.5
+	if on != c.Sostenuto {
+		c.Sostenuto = on
+		c.sampler.setSostenuto(on)
+	}
+}
+
+func (c *Controls) UpdateSoft(x float64) {
+	c.Soft = x > 0.5
+}
+
+func (c *Controls) UpdateSoftVelocity(x float64) {
+	c.SoftVelocity = x
+	Println("SoftVelocity:", x)
+}
+
+func (c *Controls) UpdateSoftFilter(x float64) {
+	c.SoftFilter = x
+	Println("SoftFilter:", x)
+}
+
+func (c *Controls) UpdateResonance(x float64) {
+	c.Resonance = x
+	Println("Resonance:", x)
+}
+
+func (c *Controls) UpdateResonanceTime(x float64) {
+	c.ResonanceTime = x
+	Println("ResonanceTime:", x)
+}
+
+func (c *Controls) UpdateResonanceLow(x float64) {
+	c.ResonanceLow = x
+	Println("ResonanceLow:", x)
+}
+
+func (c *Controls) UpdateResonanceHigh(x float64) {
+	c.ResonanceHigh = x
+	Println("ResonanceHigh:", x)
+}
+
+func (c *Controls) UpdateReverbWet(x float64) {
+	c.ReverbWet = x
+	Println("ReverbWet:", x)
+}
+
+func (c *Controls) UpdateReverbDry(x float64) {
+	c.ReverbDry = x
+	Println("ReverbDry:", x)
+}
+
+func (c *Controls) UpdateReverbPredelay(x float64) {
+	c.ReverbPredelay = x
+	Println("ReverbPredelay:", x)
+}
+
+func (c *Controls) UpdateLimiter(x float64) {
+	c.Limiter = x > 0.5
+	Println("Limiter:", c.Limiter)
+}
+
+func (c *Controls) UpdateLimiterThresh(x float64) {
+	c.LimiterThresh = x
+	Println("LimiterThresh:", x)
+}
+
+func (c *Controls) UpdateLimiterRelease(x float64) {
+	c.LimiterRelease = x
+	Println("LimiterRelease:", x)
+}
+
+func (c *Controls) UpdateLimiterLookahead(x float64) {
+	c.LimiterLookahead = x
+	Println("LimiterLookahead:", x)
+}
+
+func (c *Controls) UpdateSoftClip(x float64) {
+	c.SoftClip = x > 0.5
+	Println("SoftClip:", c.SoftClip)
+}
+
+func (c *Controls) UpdateTuning(x float64) {
+	n := int(x)
+	if n < 0 || n > len(c.tunings) {
+		Println("Unknown tuning:", n)
+		return
+	}
+	c.Tuning = n
+	c.sampler.retuneAll()
+	Println("Tuning:", n, c.tuningName())
+}
+
+// UpdateMtsReset: Clear the MTS tuning, returning its keys to the selected
+// tuning.
+func (c *Controls) UpdateMtsReset(x float64) {
+	if x < 0.5 {
+		return
+	}
+	c.mts = MtsTuning{}
+	c.sampler.retuneAll()
+	Println("MtsReset:", x)
+}
+
+func (c *Controls) UpdateEqGain(band int, x float64) {
+	c.Eq[band].Gain = x
+	Println(fmt.Sprintf("Eq%dGain:", band+1), x)
+}
+
+func (c *Controls) UpdateEqFreq(band int, x float64) {
+	c.Eq[band].Freq = x
+	Println(fmt.Sprintf("Eq%dFreq:", band+1), x)
+}
+
+func (c *Controls) UpdateEqQ(band int, x float64) {
+	c.Eq[band].Q = x
+	Println(fmt.Sprintf("Eq%dQ:", band+1), x)
 }
@@ -0,0 +1,201 @@
+package jlsampler
+
+import (
+	"math"
+)
+
+// Envelope curve shapes. See Controls.EnvCurve.
+const (
+	CurveLinear int8 = iota
+	CurveExponential
+	numCurves
+)
+
+var curveNames = []string{"linear", "exponential"}
+
+func curveName(curve int8) string {
+	if curve < 0 || curve >= numCurves {
+		return "unknown"
+	}
+	return curveNames[curve]
+}
+
+// Envelope stages.
+const (
+	envAttack = iota
+	envHold
+	envDecay
+	envSustain
+	envRelease
+	envDone
+)
+
+// ----------------------------------------------------------------------------
+// Envelope: An attack, hold, decay, sustain, release amplitude envelope for
+// a single voice. The envelope is used when Controls.Envelope is set.
+// Otherwise voices decay using the Tau controls as before.
+//
+// Linear stages move at a constant rate. Exponential stages approach their
+// target so that they are within ampCutoff of it at the end of the stage, so
+// an exponential release matches Tau when EnvRelease is Tau*ln(1/ampCutoff),
+// about 11.5*Tau.
+type Envelope struct {
+	stage  int
+	level  float64 // Double precision so exponential stages converge.
+	curve  int8
+	nHold  int     // Remaining hold samples.
+	attack float64 // Per-sample step or factor for each stage.
+	decay  float64
+	sus    float64 // Sustain level.
+	relT   float64 // Release time in seconds.
+	rel    float64
+
+	outRate float64 // Output sample rate.
+}
+
+// EnvParams: Envelope settings. Times are in seconds.
+type EnvParams struct {
+	Curve   int8
+	Attack  float64
+	Hold    float64
+	Decay   float64
+	Sustain float64
+	Release float64
+}
+
+// ampEnvParams: The amplitude envelope for a note, after velocity and key
+// tracking.
+func (c *Controls) ampEnvParams(key int, velocity float64) EnvParams {
+	p := EnvParams{
+		Curve:   c.EnvCurve,
+		Attack:  c.EnvAttack * (1 - c.EnvVelAttack*velocity),
+		Hold:    c.EnvHold,
+		Sustain: c.EnvSustain,
+	}
+	if p.Attack < 0 {
+		p.Attack = 0
+	}
+
+	keyMult := math.Pow(2, -c.EnvKeyDecay*float64(key-60)/12)
+	p.Decay = c.EnvDecay * keyMult
+	p.Release = c.EnvRelease * keyMult
+
+	return p
+}
+
+func NewEnvelope(p EnvParams, outRate float64) *Envelope {
+	env := new(Envelope)
+	env.outRate = outRate
+	env.curve = p.Curve
+	env.sus = p.Sustain
+	env.nHold = int(p.Hold * outRate)
+
+	env.attack = env.rate(p.Attack, 1)
+	env.decay = env.rate(p.Decay, 1-env.sus)
+	env.relT = p.Release
+
+	env.stage = envAttack
+	if p.Attack <= 0 {
+		env.level = 1
+		env.stage = envHold
+	}
+
+	return env
+}
+
+// rate: The per-sample step for a linear stage of the given time and
+// height, or the per-sample factor for an exponential stage. A zero time
+// gives an immediate step.
+func (env *Envelope) rate(t, height float64) float64 {
+	n := t * env.outRate
+	if n < 1 {
+		if env.curve == CurveExponential {
+			return 0
+		}
+		return height
+	}
+	if env.curve == CurveExponential {
+		return math.Exp(math.Log(ampCutoff) / n)
+	}
+	return height / n
+}
+
+// approach: Move x one sample towards target. Returns true when the target
+// is reached.
+func (env *Envelope) approach(x *float64, target, rate float64) bool {
+	if env.curve == CurveExponential {
+		*x = target + (*x-target)*rate
+		if d := *x - target; d < ampCutoff && d > -ampCutoff {
+			*x = target
+			return true
+		}
+		return false
+	}
+
+	if *x < target {
+		*x += rate
+		if *x >= target {
+			*x = target
+			return true
+		}
+	} else {
+		*x -= rate
+		if *x <= target {
+			*x = target
+			return true
+		}
+	}
+	return false
+}
+
+// Release: Start the release stage.
+func (env *Envelope) Release() {
+	if env.stage >= envRelease {
+		return
+	}
+	env.stage = envRelease
+	env.rel = env.rate(env.relT, env.level)
+}
+
+// releaseFactor: The per-sample decay factor that reaches ampCutoff in the
+// release time.
+func (env *Envelope) releaseFactor() float64 {
+	n := env.relT * env.outRate
+	if n < 1 {
+		return ampCutoff
+	}
+	return math.Exp(math.Log(ampCutoff) / n)
+}
+
+// Done: True when the release has finished.
+func (env *Envelope) Done() bool {
+	return env.stage == envDone
+}
+
+// Next: Advance one sample, returning the level.
+func (env *Envelope) Next() float32 {
+	switch env.stage {
+	case envAttack:
+		if env.approach(&env.level, 1, env.attack) {
+			env.stage = envHold
+		}
+
+	case envHold:
+		if env.nHold--; env.nHold <= 0 {
+			env.stage = envDecay
+		}
+
+	case envDecay:
+		if env.approach(&env.level, env.sus, env.decay) {
+			env.stage = envSustain
+		}
+
+	case envRelease:
+		if env.approach(&env.level, 0, env.rel) || env.level < ampCutoff {
+			env.level = 0
+			env.stage = envDone
+		}
+	}
+
+	return float32(env.level)
+}
@@ -0,0 +1,239 @@
+package jlsampler
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+// EQ band types. See EqBand.Type.
+const (
+	EqLowShelf int8 = iota
+	EqPeak
+	EqHighShelf
+	numEqTypes
+)
+
+var eqTypeNames = []string{"low-shelf", "peak", "high-shelf"}
+
+func eqTypeName(typ int8) string {
+	if typ < 0 || typ >= numEqTypes {
+		return "unknown"
+	}
+	return eqTypeNames[typ]
+}
+
+const (
+	numEqBands = 6
+	eqSettled  = 1e-12 // Flat bands are skipped once their state is below this.
+)
+
+// ----------------------------------------------------------------------------
+// EqBand: The settings for one band of the master EQ. A band with zero gain
+// is flat.
+type EqBand struct {
+	Type int8    // Band type. See EqLowShelf, etc.
+	Freq float64 // Center or corner frequency in Hz.
+	Gain float64 // Gain in dB.
+	Q    float64 // Bandwidth. For shelves, 0.707 gives no overshoot.
+}
+
+// defaultEqBands: A low shelf, four peaking bands and a high shelf, all
+// flat.
+func defaultEqBands() EqBands {
+	return EqBands{
+		{EqLowShelf, 100, 0, 0.707},
+		{EqPeak, 250, 0, 1},
+		{EqPeak, 1000, 0, 1},
+		{EqPeak, 3000, 0, 1},
+		{EqPeak, 6000, 0, 1},
+		{EqHighShelf, 10000, 0, 0.707},
+	}
+}
+
+// EqBands: The master EQ bands. In JSON, bands are given as a list of
+// objects. Omitted bands and fields keep their defaults.
+type EqBands [numEqBands]EqBand
+
+func (bands *EqBands) UnmarshalJSON(data []byte) error {
+	list := make([]EqBand, numEqBands)
+	defaults := defaultEqBands()
+	copy(list, defaults[:])
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	if len(list) > numEqBands {
+		return errors.New("Too many EQ bands.")
+	}
+	*bands = defaults
+	copy(bands[:], list)
+	return nil
+}
+
+// UnmarshalJSON: Decode a band. The type may be given by name, e.g.
+// "low-shelf", or by number.
+func (b *EqBand) UnmarshalJSON(data []byte) error {
+	type plain EqBand
+	aux := struct {
+		*plain
+		Type json.RawMessage
+	}{plain: (*plain)(b)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if len(aux.Type) == 0 {
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(aux.Type, &name); err == nil {
+		for typ, n := range eqTypeNames {
+			if n == name {
+				b.Type = int8(typ)
+				return nil
+			}
+		}
+		return errors.New("Unknown EQ type: " + name)
+	}
+
+	var typ int8
+	if err := json.Unmarshal(aux.Type, &typ); err != nil {
+		return err
+	}
+	if typ < 0 || typ >= numEqTypes {
+		return errors.New("EQ type out of range: " + string(aux.Type))
+	}
+	b.Type = typ
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// biquad: A biquad filter in transposed direct form II, with coefficients
+// from the RBJ audio EQ cookbook.
+type biquad struct {
+	band EqBand // Settings the coefficients were computed for.
+
+	b0, b1, b2, a1, a2 float64
+	zL, zR             [2]float64
+}
+
+// set: Compute coefficients for the band at the given sample rate.
+func (bq *biquad) set(band EqBand, rate float64) {
+	bq.band = band
+
+	f := band.Freq
+	if f < filterMinCutoff {
+		f = filterMinCutoff
+	} else if f > filterMaxCutoff*rate {
+		f = filterMaxCutoff * rate
+	}
+	q := band.Q
+	if q <= 0 {
+		q = 0.707
+	}
+
+	A := math.Pow(10, band.Gain/40)
+	w0 := 2 * math.Pi * f / rate
+	cw := math.Cos(w0)
+	alpha := math.Sin(w0) / (2 * q)
+
+	var b0, b1, b2, a0, a1, a2 float64
+	switch band.Type {
+	case EqLowShelf:
+		sq := 2 * math.Sqrt(A) * alpha
+		b0 = A * ((A + 1) - (A-1)*cw + sq)
+		b1 = 2 * A * ((A - 1) - (A+1)*cw)
+		b2 = A * ((A + 1) - (A-1)*cw - sq)
+		a0 = (A + 1) + (A-1)*cw + sq
+		a1 = -2 * ((A - 1) + (A+1)*cw)
+		a2 = (A + 1) + (A-1)*cw - sq
+	case EqHighShelf:
+		sq := 2 * math.Sqrt(A) * alpha
+		b0 = A * ((A + 1) + (A-1)*cw + sq)
+		b1 = -2 * A * ((A - 1) + (A+1)*cw)
+		b2 = A * ((A + 1) + (A-1)*cw - sq)
+		a0 = (A + 1) - (A-1)*cw + sq
+		a1 = 2 * ((A - 1) - (A+1)*cw)
+		a2 = (A + 1) - (A-1)*cw - sq
+	default:
+		b0 = 1 + alpha*A
+		b1 = -2 * cw
+		b2 = 1 - alpha*A
+		a0 = 1 + alpha/A
+		a1 = -2 * cw
+		a2 = 1 - alpha/A
+	}
+
+	bq.b0, bq.b1, bq.b2 = b0/a0, b1/a0, b2/a0
+	bq.a1, bq.a2 = a1/a0, a2/a0
+}
+
+// settled: True if the state is negligible, in which case it's cleared.
+func (bq *biquad) settled() bool {
+	for _, z := range [4]float64{bq.zL[0], bq.zL[1], bq.zR[0], bq.zR[1]} {
+		if z > eqSettled || z < -eqSettled {
+			return false
+		}
+	}
+	bq.zL = [2]float64{}
+	bq.zR = [2]float64{}
+	return true
+}
+
+// process: Filter one sample with the given state.
+func (bq *biquad) process(x float64, z *[2]float64) float64 {
+	y := bq.b0*x + z[0]
+	z[0] = bq.b1*x - bq.a1*y + z[1]
+	z[1] = bq.b2*x - bq.a2*y
+	return y
+}
+
+// ----------------------------------------------------------------------------
+// Eq: The master EQ, a cascade of biquads configured by Controls.Eq. Each
+// output pair has its own.
+type Eq struct {
+	rate  float64
+	bands [numEqBands]biquad
+}
+
+func NewEq(rate float64) *Eq {
+	eq := new(Eq)
+	eq.rate = rate
+	return eq
+}
+
+// ----------------------------------------------------------------------------
+// Only called from the jack callback.
+
+// processEq: Equalize each output pair.
+func (s *Sampler) processEq() {
+	for i, eq := range s.eqs {
+		if i >= s.fxPairs() {
+			break
+		}
+		eq.process(s.controls, &s.outs[i])
+	}
+}
+
+// process: Equalize buf.
+func (eq *Eq) process(c *Controls, buf *Sound) {
+	for i := range eq.bands {
+		bq := &eq.bands[i]
+		band := c.Eq[i]
+
+		if band != bq.band {
+			bq.set(band, eq.rate)
+		}
+
+		// A flat band keeps running until the state left from its last
+		// setting has died away, so that turning it off doesn't click.
+		if band.Gain == 0 && bq.settled() {
+			continue
+		}
+
+		for j := 0; j < buf.Len; j++ {
+			buf.L[j] = float32(bq.process(float64(buf.L[j]), &bq.zL))
+			buf.R[j] = float32(bq.process(float64(buf.R[j]), &bq.zR))
+		}
+	}
+}
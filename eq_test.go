@@ -0,0 +1,120 @@
+package jlsampler
+
+import (
+	"encoding/json"
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+// response: The gain in dB of the biquad at the given frequency.
+func (bq *biquad) response(f, rate float64) float64 {
+	z := cmplx.Exp(complex(0, -2*math.Pi*f/rate))
+	num := complex(bq.b0, 0) + complex(bq.b1, 0)*z + complex(bq.b2, 0)*z*z
+	den := 1 + complex(bq.a1, 0)*z + complex(bq.a2, 0)*z*z
+	return 20 * math.Log10(cmplx.Abs(num/den))
+}
+
+func TestEqResponse(t *testing.T) {
+	const rate = 48000
+	tests := []struct {
+		band    EqBand
+		f       float64 // Frequency to check.
+		want    float64 // Gain at f in dB.
+		flatOff float64 // A frequency far from the band, where it's flat.
+	}{
+		{EqBand{EqLowShelf, 200, 6, 0.707}, 10, 6, 20000},
+		{EqBand{EqLowShelf, 200, -12, 0.707}, 10, -12, 20000},
+		{EqBand{EqPeak, 1000, 6, 1}, 1000, 6, 20},
+		{EqBand{EqPeak, 1000, -9, 2}, 1000, -9, 20000},
+		{EqBand{EqHighShelf, 5000, 6, 0.707}, 23000, 6, 50},
+		{EqBand{EqHighShelf, 5000, -6, 0.707}, 23000, -6, 50},
+		{EqBand{EqPeak, 1000, 0, 1}, 1000, 0, 20},
+	}
+
+	for _, test := range tests {
+		var bq biquad
+		bq.set(test.band, rate)
+		if got := bq.response(test.f, rate); math.Abs(got-test.want) > 0.1 {
+			t.Errorf("%s at %v Hz: %.2f dB, want %v dB.",
+				eqTypeName(test.band.Type), test.f, got, test.want)
+		}
+		if got := bq.response(test.flatOff, rate); math.Abs(got) > 0.1 {
+			t.Errorf("%s at %v Hz: %.2f dB, want 0 dB.",
+				eqTypeName(test.band.Type), test.flatOff, got)
+		}
+	}
+}
+
+// Turning a band off doesn't reset its state, so there's no click.
+func TestEqGainOff(t *testing.T) {
+	const rate, n = 48000, 4800
+	c := new(Controls)
+	c.Eq = defaultEqBands()
+	c.Eq[0].Gain = 12
+	eq := NewEq(rate)
+
+	buf := NewSound(n)
+	w := 2 * math.Pi * 50 / rate
+	fill := func(i0 int) {
+		for i := range buf.L {
+			x := float32(math.Sin(w * float64(i0+i)))
+			buf.L[i], buf.R[i] = x, x
+		}
+	}
+
+	fill(0)
+	eq.process(c, buf)
+	last := buf.L[n-1]
+
+	c.Eq[0].Gain = 0
+	fill(n)
+	eq.process(c, buf)
+
+	// A 12 dB boost is four times the input, so the step between samples
+	// is at most about 4w. Resetting the state jumps by about 2.
+	if jump := math.Abs(float64(buf.L[0] - last)); jump > 8*w {
+		t.Fatalf("Output jumped by %v when the band was turned off.", jump)
+	}
+
+	for i := 2; !eq.bands[0].settled(); i++ {
+		if i == 10 {
+			t.Fatal("The flat band's state didn't die away.")
+		}
+		fill(n * i)
+		eq.process(c, buf)
+	}
+}
+
+func TestEqBandsUnmarshal(t *testing.T) {
+	var bands EqBands
+	err := json.Unmarshal([]byte(`[
+		{"Gain": 3},
+		{},
+		{"Type": "high-shelf", "Freq": 5000},
+		{"Type": 2, "Q": 2}
+	]`), &bands)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := defaultEqBands()
+	want[0].Gain = 3
+	want[2].Type = EqHighShelf
+	want[2].Freq = 5000
+	want[3].Type = EqHighShelf
+	want[3].Q = 2
+	if bands != want {
+		t.Fatalf("Got %v, want %v.", bands, want)
+	}
+
+	for _, bad := range []string{
+		`[{}, {}, {}, {}, {}, {}, {}]`,
+		`[{"Type": "notch"}]`,
+		`[{"Type": 7}]`,
+	} {
+		if err := json.Unmarshal([]byte(bad), &bands); err == nil {
+			t.Errorf("No error for %s.", bad)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package jlsampler
+
+import (
+	"sync/atomic"
+)
+
+// ----------------------------------------------------------------------------
+type EventType int8
+
+const (
+	EventNoteOn EventType = iota
+	EventNoteOff
+	EventController
+	EventPitchBend
+	EventControl
+	EventProgramChange
+	EventTuning
+)
+
+// Event: A midi or control event passed to the audio callback.
+type Event struct {
+	Type    EventType
+	Note    int8          // Note for note on/off events.
+	Control int32         // Controller or program number.
+	Value   float64       // Velocity, controller or pitch bend value.
+	Update  func(float64) // Update function for control events.
+	Tuning  *MtsTuning    // Tuning changes for tuning events.
+	Time    int64         // Event time in nanoseconds on the sampler clock.
+	Frame   int           // Frame offset in the buffer. Set in the callback.
+}
+
+// ----------------------------------------------------------------------------
+// EventQueue: A lock-free single-producer, single-consumer ring buffer.
+// The size must be a power of two.
+type EventQueue struct {
+	mask   uint64
+	events []Event
+	head   uint64 // Next index to read. Written by the consumer.
+	tail   uint64 // Next index to write. Written by the producer.
+}
+
+func NewEventQueue(size int) *EventQueue {
+	q := new(EventQueue)
+	q.mask = uint64(size - 1)
+	q.events = make([]Event, size)
+	return q
+}
+
+// Push: Add an event to the queue. Returns false if the queue is full.
+// Must only be called from a single goroutine at a time.
+func (q *EventQueue) Push(ev Event) bool {
+	tail := atomic.LoadUint64(&q.tail)
+	if tail-atomic.LoadUint64(&q.head) > q.mask {
+		return false
+	}
+	q.events[tail&q.mask] = ev
+	atomic.StoreUint64(&q.tail, tail+1)
+	return true
+}
+
+// Pop: Remove the next event from the queue. Returns false if the queue is
+// empty. Must only be called from the consumer.
+func (q *EventQueue) Pop(ev *Event) bool {
+	head := atomic.LoadUint64(&q.head)
+	if head == atomic.LoadUint64(&q.tail) {
+		return false
+	}
+	*ev = q.events[head&q.mask]
+	q.events[head&q.mask].Update = nil
+	q.events[head&q.mask].Tuning = nil
+	atomic.StoreUint64(&q.head, head+1)
+	return true
+}
@@ -0,0 +1,81 @@
+package jlsampler
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// ----------------------------------------------------------------------------
+// FFT: An in-place radix-2 complex FFT of a fixed power-of-two size.
+type FFT struct {
+	n   int
+	rev []int       // Bit-reversed index for each index.
+	tw  []complex64 // Twiddle factors exp(-2 pi i k / n) for k < n/2.
+}
+
+func NewFFT(n int) *FFT {
+	if n < 2 || n&(n-1) != 0 {
+		panic("FFT size must be a power of two.")
+	}
+
+	f := new(FFT)
+	f.n = n
+
+	bits := 0
+	for 1<<uint(bits) < n {
+		bits++
+	}
+	f.rev = make([]int, n)
+	for i := range f.rev {
+		r := 0
+		for b := 0; b < bits; b++ {
+			if i&(1<<uint(b)) != 0 {
+				r |= 1 << uint(bits-1-b)
+			}
+		}
+		f.rev[i] = r
+	}
+
+	f.tw = make([]complex64, n/2)
+	for k := range f.tw {
+		f.tw[k] = complex64(cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(n))))
+	}
+
+	return f
+}
+
+// Forward: Transform x in place. len(x) must be the FFT size.
+func (f *FFT) Forward(x []complex64) {
+	f.transform(x, false)
+}
+
+// Inverse: Inverse transform x in place, without the 1/n scaling.
+func (f *FFT) Inverse(x []complex64) {
+	f.transform(x, true)
+}
+
+func (f *FFT) transform(x []complex64, inverse bool) {
+	n := f.n
+	for i, r := range f.rev {
+		if i < r {
+			x[i], x[r] = x[r], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		half := size / 2
+		step := n / size
+		for start := 0; start < n; start += size {
+			for k := 0; k < half; k++ {
+				w := f.tw[k*step]
+				if inverse {
+					w = complex(real(w), -imag(w))
+				}
+				a := x[start+k]
+				b := x[start+k+half] * w
+				x[start+k] = a + b
+				x[start+k+half] = a - b
+			}
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package jlsampler
+
+// ----------------------------------------------------------------------------
+// FileBackend: Drives the processing callback from a timer like NullBackend,
+// and writes the output to a stereo wav file. The sampler mixes the output
+// pairs into the first.
+type FileBackend struct {
+	*NullBackend
+	path   string
+	writer *WavWriter
+}
+
+func NewFileBackend(rate int, path string) *FileBackend {
+	fb := new(FileBackend)
+	fb.NullBackend = NewNullBackend(rate)
+	fb.path = path
+	return fb
+}
+
+func (fb *FileBackend) Open(name string, nIn, nOut int) error {
+	var err error
+	if err = fb.NullBackend.Open(name, nIn, nOut); err != nil {
+		return err
+	}
+
+	if fb.writer, err = NewWavWriter(fb.path, fb.rate); err != nil {
+		return err
+	}
+
+	fb.write = func(bufOut [][]float32) error {
+		return fb.writer.Write(bufOut[0], bufOut[1])
+	}
+
+	return nil
+}
+
+func (fb *FileBackend) Close() error {
+	fb.NullBackend.Close()
+	return fb.writer.Close()
+}
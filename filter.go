@@ -0,0 +1,166 @@
+package jlsampler
+
+import (
+	"math"
+)
+
+// Filter types. See Controls.FilterType.
+const (
+	FilterOff int8 = iota
+	FilterLowPass
+	FilterHighPass
+	FilterBandPass
+	FilterNotch
+	numFilterTypes
+)
+
+var filterNames = []string{"off", "low-pass", "high-pass", "band-pass", "notch"}
+
+func filterName(typ int8) string {
+	if typ < 0 || typ >= numFilterTypes {
+		return "unknown"
+	}
+	return filterNames[typ]
+}
+
+const (
+	filterUpdate    = 32   // Samples between coefficient updates.
+	filterMinCutoff = 20.0 // Minimum cutoff in Hz.
+	filterMaxCutoff = 0.45 // Maximum cutoff as a fraction of the rate.
+)
+
+// ----------------------------------------------------------------------------
+// svfStage: The state of one state-variable filter stage. The filter is the
+// trapezoidal (zero-delay feedback) form, which stays stable while the
+// cutoff is modulated.
+type svfStage struct {
+	ic1, ic2 float32
+}
+
+// process: Filter one sample, returning the low, band and high outputs.
+func (st *svfStage) process(v0, a1, a2, a3, k float32) (float32, float32, float32) {
+	v3 := v0 - st.ic2
+	v1 := a1*st.ic1 + a2*v3
+	v2 := st.ic2 + a2*st.ic1 + a3*v3
+	st.ic1 = 2*v1 - st.ic1
+	st.ic2 = 2*v2 - st.ic2
+	return v2, v1, v0 - k*v1 - v2
+}
+
+// ----------------------------------------------------------------------------
+// VoiceFilter: A resonant filter for a single voice. The cutoff tracks
+// velocity and key, and is modulated by the filter envelope. The 24 dB
+// slope cascades two 12 dB stages.
+type VoiceFilter struct {
+	typ    int8
+	nStage int
+	rate   float64 // Output sample rate.
+	cutoff float64 // Cutoff in Hz after velocity and key tracking.
+	envAmt float64 // Envelope modulation in octaves.
+	env    *Envelope
+	count  int // Samples until the next coefficient update.
+
+	a1, a2, a3, k float32
+	L, R          [2]svfStage
+}
+
+// NewVoiceFilter: Return the filter for a note, or nil if the filter is off.
+// While the soft pedal is down, the cutoff is shifted down by SoftFilter
+// octaves. If the filter is off, a low-pass is used.
+func NewVoiceFilter(c *Controls, key int, velocity float64) *VoiceFilter {
+	typ := c.FilterType
+	soft := c.Soft && c.SoftFilter > 0
+	if typ == FilterOff && soft {
+		typ = FilterLowPass
+	}
+
+	if typ <= FilterOff || typ >= numFilterTypes {
+		return nil
+	}
+
+	f := new(VoiceFilter)
+	f.typ = typ
+	f.nStage = 1
+	if c.Filter24dB {
+		f.nStage = 2
+	}
+	f.rate = c.outRate
+
+	// Velocity tracking is in octaves below the cutoff at full velocity.
+	octaves := c.FilterVel*(velocity-1) + c.FilterKey*float64(key-60)/12
+	if soft {
+		octaves -= c.SoftFilter
+	}
+	f.cutoff = c.FilterCutoff * math.Pow(2, octaves)
+
+	// Damping is 1/Q. A Q of 0.5 or less is treated as 0.5.
+	f.k = 2
+	if c.FilterQ > 0.5 {
+		f.k = float32(1 / c.FilterQ)
+	}
+
+	if c.FilterEnvAmt != 0 {
+		f.envAmt = c.FilterEnvAmt
+		f.env = NewEnvelope(c.filterEnvParams(), c.outRate)
+	}
+
+	f.update(0)
+
+	return f
+}
+
+// update: Compute coefficients for the given envelope level.
+func (f *VoiceFilter) update(envLevel float32) {
+	fc := f.cutoff
+	if f.envAmt != 0 {
+		fc *= math.Pow(2, f.envAmt*float64(envLevel))
+	}
+	if fc < filterMinCutoff {
+		fc = filterMinCutoff
+	} else if fc > filterMaxCutoff*f.rate {
+		fc = filterMaxCutoff * f.rate
+	}
+
+	g := float32(math.Tan(math.Pi * fc / f.rate))
+	f.a1 = 1 / (1 + g*(g+f.k))
+	f.a2 = g * f.a1
+	f.a3 = g * f.a2
+}
+
+// Release: Start the release of the filter envelope.
+func (f *VoiceFilter) Release() {
+	if f.env != nil {
+		f.env.Release()
+	}
+}
+
+// Process: Filter one stereo sample.
+func (f *VoiceFilter) Process(L, R float32) (float32, float32) {
+	if f.env != nil {
+		level := f.env.Next()
+		if f.count--; f.count <= 0 {
+			f.update(level)
+			f.count = filterUpdate
+		}
+	}
+
+	for i := 0; i < f.nStage; i++ {
+		L = f.output(f.L[i].process(L, f.a1, f.a2, f.a3, f.k))
+		R = f.output(f.R[i].process(R, f.a1, f.a2, f.a3, f.k))
+	}
+
+	return L, R
+}
+
+// output: Select the output for the filter type.
+func (f *VoiceFilter) output(low, band, high float32) float32 {
+	switch f.typ {
+	case FilterHighPass:
+		return high
+	case FilterBandPass:
+		return band
+	case FilterNotch:
+		return low + high
+	}
+	return low
+}
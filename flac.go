@@ -0,0 +1,619 @@
+package jlsampler
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+)
+
+// ----------------------------------------------------------------------------
+// FLAC decoding. Files are decoded at their full bit depth. Frame header and
+// frame CRCs are checked, and so is the MD5 signature of the decoded audio if
+// the encoder wrote one.
+
+var (
+	errFlacTrunc = errors.New("Truncated flac file.")
+	errFlacCrc   = errors.New("Flac frame CRC mismatch.")
+	errFlacMd5   = errors.New("Flac MD5 signature mismatch.")
+	errFlacOrder = errors.New("Flac predictor order exceeds block size.")
+	errFlacPart  = errors.New("Invalid flac residual partition order.")
+)
+
+// flacMaxPrealloc: The most samples per channel to allocate up front. The
+// stream info length isn't trusted beyond this. Longer streams just grow.
+const flacMaxPrealloc = 1 << 22
+
+// FlacMetadata: A raw metadata block from a flac file.
+type FlacMetadata struct {
+	Type int
+	Data []byte
+}
+
+type flacStreamInfo struct {
+	rate     int
+	nChans   int
+	bits     int
+	nSamples int64
+	md5      [16]byte // All zero if not set.
+}
+
+// LoadFlac: Load a flac file.
+func LoadFlac(path string) (*PcmData, error) {
+	pcm, meta, err := loadFlacMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+	pcm.Loop = flacLoop(meta)
+	return pcm, nil
+}
+
+// loadFlacMetadata: Load a flac file, also returning its metadata blocks.
+func loadFlacMetadata(path string) (*PcmData, []FlacMetadata, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(data) < 4 || string(data[0:4]) != "fLaC" {
+		return nil, nil, errors.New("Not a flac file: " + path)
+	}
+
+	return decodeFlac(data)
+}
+
+// decodeFlac: Decode a flac file in memory.
+func decodeFlac(data []byte) (*PcmData, []FlacMetadata, error) {
+	var err error
+
+	// Metadata blocks.
+	var meta []FlacMetadata
+	var info *flacStreamInfo
+	pos := 4
+
+	for {
+		if pos+4 > len(data) {
+			return nil, nil, errFlacTrunc
+		}
+		last := data[pos]&0x80 != 0
+		typ := int(data[pos] & 0x7f)
+		size := int(data[pos+1])<<16 | int(data[pos+2])<<8 | int(data[pos+3])
+		pos += 4
+		if pos+size > len(data) {
+			return nil, nil, errFlacTrunc
+		}
+
+		block := data[pos : pos+size]
+		meta = append(meta, FlacMetadata{typ, block})
+		pos += size
+
+		if typ == 0 {
+			if info, err = parseStreamInfo(block); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if last {
+			break
+		}
+	}
+
+	if info == nil {
+		return nil, nil, errors.New("Missing flac stream info.")
+	}
+
+	// Decode frames.
+	prealloc := info.nSamples
+	if prealloc > flacMaxPrealloc {
+		prealloc = flacMaxPrealloc
+	}
+	chans := make([][]int32, info.nChans)
+	for i := range chans {
+		chans[i] = make([]int32, 0, prealloc)
+	}
+
+	// Stop at the end of the stream in case there's trailing data.
+	br := &bitReader{data: data, pos: pos * 8}
+	for br.pos/8 < len(data) {
+		if info.nSamples != 0 && int64(len(chans[0])) >= info.nSamples {
+			break
+		}
+		if err = decodeFlacFrame(br, info, chans); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Trailing samples past the stream length aren't part of the signature.
+	if info.nSamples != 0 {
+		for i := range chans {
+			if int64(len(chans[i])) > info.nSamples {
+				chans[i] = chans[i][:info.nSamples]
+			}
+		}
+	}
+	if info.md5 != [16]byte{} && flacMd5(chans, info.bits) != info.md5 {
+		return nil, nil, errFlacMd5
+	}
+
+	// Convert to floating point.
+	pcm := &PcmData{Rate: info.rate, Bits: info.bits}
+	scale := 1.0 / float32(uint32(1)<<uint(info.bits-1))
+	for _, ch := range chans {
+		x := make([]float32, len(ch))
+		for i, v := range ch {
+			x[i] = float32(v) * scale
+		}
+		pcm.Chans = append(pcm.Chans, x)
+	}
+
+	return pcm, meta, nil
+}
+
+func parseStreamInfo(b []byte) (*flacStreamInfo, error) {
+	if len(b) < 18 {
+		return nil, errors.New("Malformed flac stream info.")
+	}
+	info := new(flacStreamInfo)
+	x := binary.BigEndian.Uint64(b[10:18])
+	info.rate = int(x >> 44)
+	info.nChans = int((x>>41)&0x7) + 1
+	info.bits = int((x>>36)&0x1f) + 1
+	info.nSamples = int64(x & 0xfffffffff)
+	if len(b) >= 34 {
+		copy(info.md5[:], b[18:34])
+	}
+	return info, nil
+}
+
+// flacMd5: The MD5 signature of decoded audio: interleaved little-endian
+// samples, in whole bytes.
+func flacMd5(chans [][]int32, bits int) [16]byte {
+	nBytes := (bits + 7) / 8
+	h := md5.New()
+	buf := make([]byte, 0, 4096)
+	frame := make([]byte, 4)
+	for i := range chans[0] {
+		for _, ch := range chans {
+			binary.LittleEndian.PutUint32(frame, uint32(ch[i]))
+			buf = append(buf, frame[:nBytes]...)
+		}
+		if len(buf) >= 4000 {
+			h.Write(buf)
+			buf = buf[:0]
+		}
+	}
+	h.Write(buf)
+
+	var sum [16]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// crc8: The frame header CRC, polynomial x^8 + x^2 + x + 1.
+func crc8(b []byte) byte {
+	var crc byte
+	for _, x := range b {
+		crc ^= x
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// crc16: The frame CRC, polynomial x^16 + x^15 + x^2 + 1.
+func crc16(b []byte) uint16 {
+	var crc uint16
+	for _, x := range b {
+		crc ^= uint16(x) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// ----------------------------------------------------------------------------
+type bitReader struct {
+	data []byte
+	pos  int // Position in bits.
+}
+
+func (br *bitReader) read(n int) (uint64, error) {
+	if br.pos+n > len(br.data)*8 {
+		return 0, errFlacTrunc
+	}
+	var x uint64
+	for n > 0 {
+		avail := 8 - br.pos&7
+		take := avail
+		if take > n {
+			take = n
+		}
+		b := uint64(br.data[br.pos>>3]) >> uint(avail-take)
+		x = x<<uint(take) | b&(1<<uint(take)-1)
+		n -= take
+		br.pos += take
+	}
+	return x, nil
+}
+
+func (br *bitReader) readSigned(n int) (int64, error) {
+	x, err := br.read(n)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	shift := uint(64 - n)
+	return int64(x<<shift) >> shift, nil
+}
+
+// readUnary: Count zero bits before the next one bit.
+func (br *bitReader) readUnary() (int, error) {
+	n := 0
+	for {
+		if br.pos >= len(br.data)*8 {
+			return 0, errFlacTrunc
+		}
+		// Skip whole zero bytes when aligned.
+		if br.pos&7 == 0 && br.data[br.pos>>3] == 0 {
+			n += 8
+			br.pos += 8
+			continue
+		}
+		bit := (br.data[br.pos>>3] >> uint(7-br.pos&7)) & 1
+		br.pos++
+		if bit == 1 {
+			return n, nil
+		}
+		n++
+	}
+}
+
+func (br *bitReader) align() {
+	br.pos = (br.pos + 7) &^ 7
+}
+
+// ----------------------------------------------------------------------------
+func decodeFlacFrame(br *bitReader, info *flacStreamInfo, chans [][]int32) error {
+	start := br.pos / 8 // Frames are byte aligned.
+	sync, err := br.read(15)
+	if err != nil {
+		return err
+	}
+	if sync != 0x3ffe<<1 && sync != 0x3ffe<<1|1 {
+		return errors.New("Lost flac frame sync.")
+	}
+	br.read(1) // Blocking strategy.
+
+	bsCode, _ := br.read(4)
+	rateCode, _ := br.read(4)
+	chanCode, _ := br.read(4)
+	sizeCode, _ := br.read(3)
+	br.read(1)
+
+	// Frame or sample number, utf-8 coded.
+	first, err := br.read(8)
+	if err != nil {
+		return err
+	}
+	for mask := uint64(0x80); first&mask != 0 && mask > 1; mask >>= 1 {
+		if mask != 0x80 {
+			br.read(8)
+		}
+	}
+
+	var blockSize int
+	switch {
+	case bsCode == 1:
+		blockSize = 192
+	case bsCode >= 2 && bsCode <= 5:
+		blockSize = 576 << (bsCode - 2)
+	case bsCode == 6:
+		x, _ := br.read(8)
+		blockSize = int(x) + 1
+	case bsCode == 7:
+		x, _ := br.read(16)
+		blockSize = int(x) + 1
+	case bsCode >= 8:
+		blockSize = 256 << (bsCode - 8)
+	default:
+		return errors.New("Invalid flac block size.")
+	}
+
+	switch rateCode {
+	case 12:
+		br.read(8)
+	case 13, 14:
+		br.read(16)
+	}
+
+	bits := info.bits
+	switch sizeCode {
+	case 1:
+		bits = 8
+	case 2:
+		bits = 12
+	case 4:
+		bits = 16
+	case 5:
+		bits = 20
+	case 6:
+		bits = 24
+	case 7:
+		bits = 32
+	}
+
+	headerEnd := br.pos / 8
+	crc, err := br.read(8)
+	if err != nil {
+		return err
+	}
+	if byte(crc) != crc8(br.data[start:headerEnd]) {
+		return errFlacCrc
+	}
+
+	nChans := int(chanCode) + 1
+	if chanCode >= 8 {
+		nChans = 2
+	}
+	if chanCode > 10 || nChans != len(chans) {
+		return errors.New("Unsupported flac channel assignment.")
+	}
+
+	// Decode subframes. The side channel has an extra bit.
+	block := make([][]int32, nChans)
+	for c := 0; c < nChans; c++ {
+		b := bits
+		if (chanCode == 8 && c == 1) || (chanCode == 9 && c == 0) ||
+			(chanCode == 10 && c == 1) {
+			b++
+		}
+		block[c] = make([]int32, blockSize)
+		if err = decodeSubframe(br, b, block[c]); err != nil {
+			return err
+		}
+	}
+
+	br.align()
+	frameEnd := br.pos / 8
+	if crc, err = br.read(16); err != nil {
+		return err
+	}
+	if uint16(crc) != crc16(br.data[start:frameEnd]) {
+		return errFlacCrc
+	}
+
+	// Inter-channel decorrelation.
+	switch chanCode {
+	case 8: // Left, side.
+		for i := range block[0] {
+			block[1][i] = block[0][i] - block[1][i]
+		}
+	case 9: // Side, right.
+		for i := range block[0] {
+			block[0][i] += block[1][i]
+		}
+	case 10: // Mid, side.
+		for i := range block[0] {
+			side := block[1][i]
+			mid := block[0][i]<<1 | side&1
+			block[0][i] = (mid + side) >> 1
+			block[1][i] = (mid - side) >> 1
+		}
+	}
+
+	for c := range chans {
+		chans[c] = append(chans[c], block[c]...)
+	}
+
+	return nil
+}
+
+var fixedCoeffs = [][]int64{
+	{},
+	{1},
+	{2, -1},
+	{3, -3, 1},
+	{4, -6, 4, -1},
+}
+
+func decodeSubframe(br *bitReader, bits int, out []int32) error {
+	hdr, err := br.read(8)
+	if err != nil {
+		return err
+	}
+	typ := int(hdr>>1) & 0x3f
+
+	// Wasted bits.
+	wasted := 0
+	if hdr&1 != 0 {
+		n, err := br.readUnary()
+		if err != nil {
+			return err
+		}
+		wasted = n + 1
+		bits -= wasted
+		if bits < 1 {
+			return errors.New("Invalid flac wasted bits.")
+		}
+	}
+
+	switch {
+	case typ == 0: // Constant.
+		x, err := br.readSigned(bits)
+		if err != nil {
+			return err
+		}
+		for i := range out {
+			out[i] = int32(x)
+		}
+
+	case typ == 1: // Verbatim.
+		for i := range out {
+			x, err := br.readSigned(bits)
+			if err != nil {
+				return err
+			}
+			out[i] = int32(x)
+		}
+
+	case typ >= 8 && typ <= 12: // Fixed.
+		order := typ - 8
+		if order > len(out) {
+			return errFlacOrder
+		}
+		if err = decodeWarmup(br, bits, out[:order]); err != nil {
+			return err
+		}
+		if err = decodeResidual(br, order, out); err != nil {
+			return err
+		}
+		predict(out, fixedCoeffs[order], 0)
+
+	case typ >= 32: // LPC.
+		order := typ - 31
+		if order > len(out) {
+			return errFlacOrder
+		}
+		if err = decodeWarmup(br, bits, out[:order]); err != nil {
+			return err
+		}
+		precision, err := br.read(4)
+		if err != nil {
+			return err
+		}
+		if precision == 15 {
+			return errors.New("Invalid flac lpc precision.")
+		}
+		shift, err := br.readSigned(5)
+		if err != nil {
+			return err
+		}
+		if shift < 0 {
+			return errors.New("Invalid flac lpc shift.")
+		}
+		coeffs := make([]int64, order)
+		for i := range coeffs {
+			if coeffs[i], err = br.readSigned(int(precision) + 1); err != nil {
+				return err
+			}
+		}
+		if err = decodeResidual(br, order, out); err != nil {
+			return err
+		}
+		predict(out, coeffs, uint(shift))
+
+	default:
+		return errors.New("Invalid flac subframe type.")
+	}
+
+	if wasted != 0 {
+		for i := range out {
+			out[i] <<= uint(wasted)
+		}
+	}
+
+	return nil
+}
+
+func decodeWarmup(br *bitReader, bits int, out []int32) error {
+	for i := range out {
+		x, err := br.readSigned(bits)
+		if err != nil {
+			return err
+		}
+		out[i] = int32(x)
+	}
+	return nil
+}
+
+// decodeResidual: Read rice coded residuals into out[order:].
+func decodeResidual(br *bitReader, order int, out []int32) error {
+	method, err := br.read(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return errors.New("Invalid flac residual coding method.")
+	}
+	paramBits := 4 + int(method)
+	escape := uint64(1)<<uint(paramBits) - 1
+
+	partOrder, err := br.read(4)
+	if err != nil {
+		return err
+	}
+	nParts := 1 << partOrder
+	partLen := len(out) >> partOrder
+	if partLen*nParts != len(out) || partLen < order {
+		return errFlacPart
+	}
+
+	i := order
+	for p := 0; p < nParts; p++ {
+		n := partLen
+		if p == 0 {
+			n -= order
+		}
+		if n < 0 || i+n > len(out) {
+			return errors.New("Invalid flac residual partition.")
+		}
+
+		param, err := br.read(paramBits)
+		if err != nil {
+			return err
+		}
+
+		if param == escape {
+			rawBits, err := br.read(5)
+			if err != nil {
+				return err
+			}
+			for j := 0; j < n; j++ {
+				x, err := br.readSigned(int(rawBits))
+				if err != nil {
+					return err
+				}
+				out[i] = int32(x)
+				i++
+			}
+			continue
+		}
+
+		for j := 0; j < n; j++ {
+			q, err := br.readUnary()
+			if err != nil {
+				return err
+			}
+			r, err := br.read(int(param))
+			if err != nil {
+				return err
+			}
+			u := uint32(q)<<uint(param) | uint32(r)
+			out[i] = int32(u>>1) ^ -int32(u&1)
+			i++
+		}
+	}
+
+	return nil
+}
+
+// predict: Add the linear prediction to the residuals in x[len(coeffs):].
+func predict(x []int32, coeffs []int64, shift uint) {
+	order := len(coeffs)
+	for i := order; i < len(x); i++ {
+		var sum int64
+		for j, c := range coeffs {
+			sum += c * int64(x[i-1-j])
+		}
+		x[i] += int32(sum >> shift)
+	}
+}
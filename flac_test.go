@@ -0,0 +1,118 @@
+package jlsampler
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// Sample formulas for testdata/stereo24.flac.
+func flacTestLeft(i int) int32 {
+	x := (i * 20000) % 8000000
+	if x < 4000000 {
+		return int32(4000000 - x - 2000000)
+	}
+	return int32(x - 4000000 - 2000000)
+}
+
+func flacTestRight(i int) int32 {
+	if i >= 2048 {
+		return 12345
+	}
+	// Floor division, as in the generator.
+	l := flacTestLeft(i)
+	half := l / 2
+	if l < 0 && l%2 != 0 {
+		half--
+	}
+	return half + int32((i*i*31)%1000) - 500
+}
+
+func readFlacTestFile(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestFlacDecode(t *testing.T) {
+	pcm, _, err := decodeFlac(readFlacTestFile(t, "stereo24.flac"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pcm.Rate != 48000 || pcm.Bits != 24 || len(pcm.Chans) != 2 {
+		t.Fatalf("Wrong format: %d Hz, %d bits, %d channels.",
+			pcm.Rate, pcm.Bits, len(pcm.Chans))
+	}
+
+	scale := 1.0 / float32(1<<23)
+	for c, fn := range []func(int) int32{flacTestLeft, flacTestRight} {
+		if len(pcm.Chans[c]) != 3000 {
+			t.Fatalf("Channel %d: %d samples.", c, len(pcm.Chans[c]))
+		}
+		for i, x := range pcm.Chans[c] {
+			if want := float32(fn(i)) * scale; x != want {
+				t.Fatalf("Channel %d sample %d: %v, want %v.", c, i, x, want)
+			}
+		}
+	}
+}
+
+func TestFlacCorrupt(t *testing.T) {
+	data := readFlacTestFile(t, "stereo24.flac")
+	start := 4 + 4 + 34 // Frames follow the stream info.
+
+	// A bit error anywhere in the frames must be caught.
+	for pos := start; pos < len(data); pos++ {
+		bad := append([]byte(nil), data...)
+		bad[pos] ^= 1 << uint(pos%8)
+		if _, _, err := decodeFlac(bad); err == nil {
+			t.Fatalf("Byte %d corrupted: no error.", pos)
+		}
+	}
+}
+
+func TestFlacTruncated(t *testing.T) {
+	data := readFlacTestFile(t, "stereo24.flac")
+	for n := 4; n < len(data); n++ {
+		if _, _, err := decodeFlac(data[:n]); err == nil {
+			t.Fatalf("Truncated to %d bytes: no error.", n)
+		}
+	}
+}
+
+func TestFlacMd5(t *testing.T) {
+	data := readFlacTestFile(t, "stereo24.flac")
+	data[4+4+18] ^= 1 // First byte of the MD5 signature.
+	if _, _, err := decodeFlac(data); err != errFlacMd5 {
+		t.Fatalf("Got %v, want %v.", err, errFlacMd5)
+	}
+}
+
+func TestFlacPartitionOrder(t *testing.T) {
+	_, _, err := decodeFlac(readFlacTestFile(t, "ffmpeg-partition.flac"))
+	if err != errFlacPart {
+		t.Fatalf("Got %v, want %v.", err, errFlacPart)
+	}
+}
+
+func TestFlacSubframeOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		hdr  byte
+	}{
+		{"fixed order 4", (8 + 4) << 1},
+		{"lpc order 32", (31 + 32) << 1},
+	}
+
+	for _, tt := range tests {
+		// Plenty of data, so only the order check can fail.
+		data := make([]byte, 256)
+		data[0] = tt.hdr
+		br := &bitReader{data: data}
+		if err := decodeSubframe(br, 16, make([]int32, 3)); err != errFlacOrder {
+			t.Errorf("%s: got %v, want %v.", tt.name, err, errFlacOrder)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package jlsampler
+
+import (
+	"math"
+)
+
+// ----------------------------------------------------------------------------
+// Interpolation kernels for real-time playback. See Controls.Interpolation.
+type InterpKernel int8
+
+const (
+	KernelLinear InterpKernel = iota
+	KernelHermite
+	KernelBSpline
+	KernelSinc8
+	KernelSinc16
+	numKernels
+)
+
+var kernelNames = []string{"linear", "hermite", "bspline", "sinc8", "sinc16"}
+
+func (k InterpKernel) String() string {
+	if k < 0 || k >= numKernels {
+		return "unknown"
+	}
+	return kernelNames[k]
+}
+
+const (
+	sincPhases = 1024 // Fractional positions in the sinc tables.
+	sincBeta   = 6.0  // Kaiser window shape parameter.
+)
+
+var sinc8Table = makeSincTable(8)
+var sinc16Table = makeSincTable(16)
+
+// makeSincTable: Kaiser windowed sinc weights for sincPhases+1 fractional
+// positions from 0 to 1.
+func makeSincTable(taps int) [][]float32 {
+	table := make([][]float32, sincPhases+1)
+	half := taps / 2
+	i0Beta := besselI0(sincBeta)
+
+	for p := range table {
+		mu := float64(p) / sincPhases
+		w := make([]float32, taps)
+		sum := 0.0
+		for j := range w {
+			u := mu + float64(half-1-j)
+			sinc := 1.0
+			if u != 0 {
+				sinc = math.Sin(math.Pi*u) / (math.Pi * u)
+			}
+			x := u / float64(half)
+			win := 0.0
+			if x*x < 1 {
+				win = besselI0(sincBeta*math.Sqrt(1-x*x)) / i0Beta
+			}
+			w[j] = float32(sinc * win)
+			sum += sinc * win
+		}
+		// Normalize for unity gain at DC.
+		for j := range w {
+			w[j] = float32(float64(w[j]) / sum)
+		}
+		table[p] = w
+	}
+
+	return table
+}
+
+// kernelWeights: Fill w with the weights for fractional position mu. Returns
+// the number of taps. The first tap applies to sample floor(idx)-taps/2+1.
+func kernelWeights(k InterpKernel, mu float32, w []float32) int {
+	mu2 := mu * mu
+	mu3 := mu2 * mu
+
+	switch k {
+	case KernelHermite:
+		// 4-point, 3rd-order hermite (Catmull-Rom).
+		w[0] = -0.5*mu + mu2 - 0.5*mu3
+		w[1] = 1 - 2.5*mu2 + 1.5*mu3
+		w[2] = 0.5*mu + 2*mu2 - 1.5*mu3
+		w[3] = -0.5*mu2 + 0.5*mu3
+		return 4
+
+	case KernelBSpline:
+		// Cubic b-spline. This smooths rather than interpolates.
+		m := 1 - mu
+		w[0] = m * m * m / 6
+		w[1] = (3*mu3 - 6*mu2 + 4) / 6
+		w[2] = (-3*mu3 + 3*mu2 + 3*mu + 1) / 6
+		w[3] = mu3 / 6
+		return 4
+
+	case KernelSinc8:
+		return sincWeights(sinc8Table, mu, w)
+
+	case KernelSinc16:
+		return sincWeights(sinc16Table, mu, w)
+	}
+
+	w[0] = 1 - mu
+	w[1] = mu
+	return 2
+}
+
+// sincWeights: Linearly interpolate between the two nearest phases in table.
+func sincWeights(table [][]float32, mu float32, w []float32) int {
+	x := mu * sincPhases
+	p := int(x)
+	if p >= sincPhases {
+		p = sincPhases - 1
+	}
+	f := x - float32(p)
+	w0, w1 := table[p], table[p+1]
+	for j := range w0 {
+		w[j] = w0[j] + f*(w1[j]-w0[j])
+	}
+	return len(w0)
+}
+
+// InterpKernel: Return interpolated L and R samples for the given index
+// using the given kernel. Samples outside the sample are taken as zero.
+func (s *Sample) InterpKernel(idx float64, k InterpKernel) (float32, float32) {
+	if k == KernelLinear {
+		return s.Interp(idx)
+	}
+
+	iIdx := int(idx)
+	return s.interpAt(iIdx, float32(idx-float64(iIdx)), k)
+}
+
+// InterpLoop: Like InterpKernel, for a voice playing the given loop. Taps at
+// or past the loop end are read from where playback continues: the loop
+// start for forward loops, or reflected back into the loop for ping-pong
+// loops. This keeps the seam continuous, and never reads past a loop that
+// ends at the end of the sample.
+func (s *Sample) InterpLoop(
+	idx float64, k InterpKernel, loop *SampleLoop, pingPong bool) (
+	float32, float32) {
+
+	iIdx := int(idx)
+	mu := float32(idx - float64(iIdx))
+
+	var w [16]float32
+	n := kernelWeights(k, mu, w[:])
+	start := iIdx - n/2 + 1
+	if start+n <= loop.End {
+		return s.InterpKernel(idx, k)
+	}
+
+	last := loop.End - 1
+	var L, R float32
+	for j := 0; j < n; j++ {
+		i := start + j
+		for i > last {
+			if pingPong {
+				i = 2*last - i
+			} else {
+				i -= loop.End - loop.Start
+			}
+		}
+		if i >= 0 && i < s.Len {
+			l, r := s.At(i)
+			L += w[j] * l
+			R += w[j] * r
+		}
+	}
+
+	return L, R
+}
+
+// interpAt: Interpolate at fractional position mu after index iIdx.
+func (s *Sample) interpAt(iIdx int, mu float32, k InterpKernel) (float32, float32) {
+	var w [16]float32
+	n := kernelWeights(k, mu, w[:])
+	start := iIdx - n/2 + 1
+
+	var L, R float32
+	for j := 0; j < n; j++ {
+		i := start + j
+		if i >= 0 && i < s.Len {
+			l, r := s.At(i)
+			L += w[j] * l
+			R += w[j] * r
+		}
+	}
+
+	return L, R
+}
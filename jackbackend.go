@@ -0,0 +1,34 @@
+package jlsampler
+
+import (
+	"github.com/johnnylee/jackclient"
+)
+
+// ----------------------------------------------------------------------------
+// JackBackend: Audio output through a jack client.
+type JackBackend struct {
+	client *jackclient.JackClient
+}
+
+func NewJackBackend() *JackBackend {
+	return new(JackBackend)
+}
+
+func (jb *JackBackend) Open(name string, nIn, nOut int) error {
+	var err error
+	jb.client, err = jackclient.New(name, nIn, nOut)
+	return err
+}
+
+func (jb *JackBackend) SampleRate() int {
+	return jb.client.GetSampleRate()
+}
+
+func (jb *JackBackend) RegisterCallback(fn ProcessFunc) {
+	jb.client.RegisterCallback(fn)
+}
+
+func (jb *JackBackend) Close() error {
+	jb.client.Close()
+	return nil
+}
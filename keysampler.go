@@ -11,6 +11,16 @@ type KeySampler struct {
 	Key      int            // The midi key number.
 	on       bool           // True if key is on (down).
 	layers   []*SampleLayer // The sample layers.
+	release  []*SampleLayer // Release sample layers. May be empty.
+	soft     []*SampleLayer // Soft pedal sample layers. May be empty.
+
+	velocity       float64 // Velocity of the last note on.
+	onTime         float64 // Time of the last note on in seconds.
+	releasePending bool    // Trigger release when the sustain pedal lifts.
+	sostenuto      bool    // Held by the sostenuto pedal.
+
+	out        int // Output pair for notes.
+	releaseOut int // Output pair for release samples.
 
 	// A slice of playing samples. The length is the number of playing samples.
 	playing []*PlayingSample
@@ -30,6 +40,12 @@ func (ks *KeySampler) Copy() *KeySampler {
 	for i := 0; i < len(ks.layers); i++ {
 		ks2.layers = append(ks2.layers, ks.layers[i].Copy())
 	}
+	for _, sl := range ks.release {
+		ks2.release = append(ks2.release, sl.Copy())
+	}
+	for _, sl := range ks.soft {
+		ks2.soft = append(ks2.soft, sl.Copy())
+	}
 	return ks2
 }
 
@@ -74,6 +90,12 @@ func (ks *KeySampler) Transpose(trans int) *KeySampler {
 	for _, layer := range ks.layers {
 		ks2.layers = append(ks2.layers, layer.Transpose(trans))
 	}
+	for _, layer := range ks.release {
+		ks2.release = append(ks2.release, layer.Transpose(trans))
+	}
+	for _, layer := range ks.soft {
+		ks2.soft = append(ks2.soft, layer.Transpose(trans))
+	}
 
 	ks2.playing = make([]*PlayingSample, 0, cap(ks.playing))
 	return ks2
@@ -81,15 +103,23 @@ func (ks *KeySampler) Transpose(trans int) *KeySampler {
 
 // TODO: Clean up this code.
 func (ks *KeySampler) getPlayingSample(velocity float64) *PlayingSample {
+	// Use the soft pedal layers if there are any.
+	layers := ks.layers
+	if ks.controls.Soft && len(ks.soft) != 0 {
+		layers = ks.soft
+	}
+
 	if ks.controls.MixLayers {
-		return ks.getPlayingSampleMix(velocity)
+		return ks.getPlayingSampleMix(layers, velocity)
 	} else {
-		return ks.getPlayingSampleBasic(velocity)
+		return ks.getPlayingSampleBasic(layers, velocity)
 	}
 }
 
-func (ks *KeySampler) getPlayingSampleBasic(velocity float64) *PlayingSample {
-	numLayers := int64(len(ks.layers))
+func (ks *KeySampler) getPlayingSampleBasic(
+	layers []*SampleLayer, velocity float64) *PlayingSample {
+
+	numLayers := int64(len(layers))
 	
 	// Get the layer.
 	layer := int64(
@@ -100,7 +130,7 @@ func (ks *KeySampler) getPlayingSampleBasic(velocity float64) *PlayingSample {
 	}
 	
 	// Get a sample from the first layer.
-	_, sample := ks.layers[layer].GetSample(-1)
+	_, sample := layers[layer].GetSample(-1)
 
 	// Compute the amplitude of the sample.
 	amp := ks.controls.CalcAmp(ks.Key, velocity, sample.Rms)
@@ -111,8 +141,10 @@ func (ks *KeySampler) getPlayingSampleBasic(velocity float64) *PlayingSample {
 	return NewPlayingSample(ks.controls, sample, nil, amp, 0, pan, 0)
 }
 
-func (ks *KeySampler) getPlayingSampleMix(velocity float64) *PlayingSample {
-	numLayers := int64(len(ks.layers))
+func (ks *KeySampler) getPlayingSampleMix(
+	layers []*SampleLayer, velocity float64) *PlayingSample {
+
+	numLayers := int64(len(layers))
 
 	layerVal := float32(
 		float64(numLayers-1) * math.Pow(velocity, ks.controls.GammaLayer))
@@ -127,8 +159,8 @@ func (ks *KeySampler) getPlayingSampleMix(velocity float64) *PlayingSample {
 	mix := layerVal - float32(layer1)
 
 	// Samples.
-	sIdx, sample1 := ks.layers[layer1].GetSample(-1)
-	_, sample2 := ks.layers[layer2].GetSample(sIdx)
+	sIdx, sample1 := layers[layer1].GetSample(-1)
+	_, sample2 := layers[layer2].GetSample(sIdx)
 
 	// Amps.
 	amp1 := ks.controls.CalcAmp(ks.Key, velocity, sample1.Rms)
@@ -140,61 +172,108 @@ func (ks *KeySampler) getPlayingSampleMix(velocity float64) *PlayingSample {
 	return NewPlayingSample(ks.controls, sample1, sample2, amp1, amp2, pan, mix)
 }
 
-func (ks *KeySampler) NoteOn(velocity float64) {
+// NoteOn: Start a new voice at time now (in seconds), returning it.
+func (ks *KeySampler) NoteOn(velocity, now float64) *PlayingSample {
+	if ks.controls.Soft {
+		velocity *= ks.controls.SoftVelocity
+	}
+
 	ks.on = true
+	ks.velocity = velocity
+	ks.onTime = now
+	ks.releasePending = false
 
 	// Loop through playing samples. All currently playing samples should
 	// decay with constant tauCut.
 	if ks.controls.TauCut != 0 {
 		for _, ps := range ks.playing {
-			ps.tau = float32(ks.controls.TauCut)
+			if !ps.stolen {
+				ps.tau = float32(ks.controls.TauCut)
+			}
 		}
 	}
 
 	// Add a new playing sample.
-	ks.playing = append(ks.playing, ks.getPlayingSample(velocity))
+	ps := ks.getPlayingSample(velocity)
+	if ks.controls.Envelope {
+		ps.env = NewEnvelope(
+			ks.controls.ampEnvParams(ks.Key, velocity), ks.controls.outRate)
+	}
+	ps.filter = NewVoiceFilter(ks.controls, ks.Key, velocity)
+	ps.rate = ks.playbackRate()
+	ks.playing = append(ks.playing, ps)
+	return ps
+}
+
+// playbackRate: The playback rate multiplier for the key's tuning.
+func (ks *KeySampler) playbackRate() float32 {
+	offset := ks.controls.tuningOffset(ks.Key)
+	if offset == 0 {
+		return 1
+	}
+	return float32(math.Pow(2, offset/12))
 }
 
-func (ks *KeySampler) NoteOff() {
+// retune: Set the playback rate of the sounding voices after the tuning
+// changes.
+func (ks *KeySampler) retune() {
+	rate := ks.playbackRate()
+	for _, ps := range ks.playing {
+		ps.rate = rate
+	}
+}
+
+// NoteOff: Release the key at time now (in seconds).
+func (ks *KeySampler) NoteOff(now float64) {
 	ks.on = false
 
-	// If sustaining, there's nothing to do.
-	if ks.controls.Sustain {
+	// If sustaining, the release sample is triggered when the pedal lifts.
+	if ks.sustained() {
+		ks.releasePending = true
 		return
 	}
 
-	// Loop through playing sounds. If any aren't decaying, then
-	// they need to have tau set.
-	if ks.controls.Tau != 0 {
-		for _, ps := range ks.playing {
-			if ps.tau == 0 {
-				ps.tau = float32(ks.controls.Tau)
-			}
-		}
+	// Loop through playing sounds, releasing any that aren't decaying.
+	for _, ps := range ks.playing {
+		ps.Release()
 	}
+
+	ks.triggerRelease(now)
+}
+
+// sustained: True if the key is held by the sustain or sostenuto pedal.
+func (ks *KeySampler) sustained() bool {
+	return ks.controls.Sustain || ks.sostenuto
 }
 
 func (ks *KeySampler) HasData() bool {
 	return len(ks.playing) != 0
 }
 
-func (ks *KeySampler) WriteOutput(buf *Sound, amp, di []float32) {
+// WriteOutput: Write notes to buf and release samples to rbuf.
+func (ks *KeySampler) WriteOutput(buf, rbuf *Sound, amp, di []float32) {
 	var ps *PlayingSample
 
-	// Check for sustain pedal depressed.
+	// Check for sustain pedal depressed. Stolen voices keep fading.
 	ps = ks.playing[len(ks.playing)-1]
-	if ks.controls.Sustain && ps.tau != 0 {
+	if ks.controls.Sustain && ps.tau != 0 && !ps.stolen {
 		ps.tau = 0
 	}
 
 	iIn := 0
 	for _, ps = range ks.playing {
 		// Check for sustain pedal lift.
-		if !ks.on && !ks.controls.Sustain && ps.tau == 0 {
-			ps.tau = float32(ks.controls.Tau)
+		if !ks.on && !ks.sustained() {
+			ps.Release()
+		} else if !ks.on && !ks.sostenuto {
+			ps.halfDamp(ks.controls.pedalLift)
 		}
 
-		if ps.WriteOutput(buf, amp, di) {
+		out := buf
+		if ps.release {
+			out = rbuf
+		}
+		if ps.WriteOutput(out, amp, di) {
 			ks.playing[iIn] = ps
 			iIn++
 		}
@@ -203,14 +282,63 @@ func (ks *KeySampler) WriteOutput(buf *Sound, amp, di []float32) {
 	ks.playing = ks.playing[:iIn]
 }
 
+// allLayers: The note, soft and release layers.
+func (ks *KeySampler) allLayers() []*SampleLayer {
+	if len(ks.release) == 0 && len(ks.soft) == 0 {
+		return ks.layers
+	}
+	all := make(
+		[]*SampleLayer, 0, len(ks.layers)+len(ks.soft)+len(ks.release))
+	all = append(all, ks.layers...)
+	all = append(all, ks.soft...)
+	return append(all, ks.release...)
+}
+
+// AddSoftSample: Add a sample to the soft pedal layers.
+func (ks *KeySampler) AddSoftSample(sample *Sample, layer int) {
+	for len(ks.soft) < layer+1 {
+		ks.soft = append(ks.soft, new(SampleLayer))
+	}
+	ks.soft[layer].AddSample(sample)
+}
+
+// fillSoftLayers: Use the note layer for any soft layer without samples, so
+// that a sample set may provide soft samples for only some layers. Called
+// after loading.
+func (ks *KeySampler) fillSoftLayers() {
+	if len(ks.layers) == 0 {
+		return
+	}
+	for i, sl := range ks.soft {
+		if sl.NumSamples() != 0 {
+			continue
+		}
+		j := i
+		if j > len(ks.layers)-1 {
+			j = len(ks.layers) - 1
+		}
+		ks.soft[i] = ks.layers[j]
+	}
+}
+
+// noteLayers: The note and soft layers, which are normalized by RMS.
+func (ks *KeySampler) noteLayers() []*SampleLayer {
+	if len(ks.soft) == 0 {
+		return ks.layers
+	}
+	all := make([]*SampleLayer, 0, len(ks.layers)+len(ks.soft))
+	all = append(all, ks.layers...)
+	return append(all, ks.soft...)
+}
+
 func (ks *KeySampler) UpdateCropThresh(thresh float64) {
-	for _, sl := range ks.layers {
+	for _, sl := range ks.noteLayers() {
 		sl.UpdateCropThresh(thresh)
 	}
 }
 
 func (ks *KeySampler) UpdateRms(rmsTime float64) {
-	for _, sl := range ks.layers {
+	for _, sl := range ks.noteLayers() {
 		sl.UpdateRms(rmsTime)
 	}
 }
@@ -0,0 +1,46 @@
+package jlsampler
+
+import (
+	"testing"
+)
+
+func newTestSample(rms float64) *Sample {
+	s := NewSample(64, false, false)
+	s.Rms = rms
+	return s
+}
+
+// A sample set with soft samples for only the top layer.
+func TestSparseSoftLayers(t *testing.T) {
+	c := NewControls(nil)
+	c.Soft = true
+	ks := NewKeySampler(c, 60)
+
+	notes := []*Sample{newTestSample(1), newTestSample(2), newTestSample(3)}
+	for i, s := range notes {
+		ks.AddLayer()
+		ks.AddSample(s, i)
+	}
+	soft := newTestSample(4)
+	ks.AddSoftSample(soft, 2)
+	ks.fillSoftLayers()
+
+	tests := []struct {
+		velocity float64
+		want     *Sample
+	}{
+		{0.1, notes[0]},
+		{0.5, notes[1]},
+		{1, soft},
+	}
+
+	for _, mix := range []bool{false, true} {
+		c.MixLayers = mix
+		for _, test := range tests {
+			ps := ks.getPlayingSample(test.velocity)
+			if !mix && ps.sample1 != test.want {
+				t.Errorf("Velocity %v: wrong sample.", test.velocity)
+			}
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package jlsampler
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+const (
+	limiterOversample   = 4     // True-peak oversampling factor.
+	limiterTaps         = 8     // Interpolation taps per phase.
+	limiterMaxLookahead = 0.02  // Maximum lookahead in seconds.
+	limiterSoftKnee     = 0.5   // Soft clipping starts at this fraction.
+	limiterFloor        = 1e-10 // Smaller gains are reported as this.
+)
+
+// ----------------------------------------------------------------------------
+// Limiter: A lookahead true-peak limiter, with an optional soft clipper after
+// it. Each output pair has its own limiter, so that they all have the same
+// latency.
+//
+// Inter-sample peaks are estimated by oversampling with a windowed-sinc
+// interpolator. The gain needed to keep each sample under the threshold is
+// held for the lookahead time, released exponentially, and smoothed with a
+// moving average over the lookahead time. The signal is delayed so that the
+// smoothed gain has reached its target before a peak is output, so the
+// output doesn't exceed the threshold. The interpolated peaks lag the input
+// by half the interpolator length, so the delay is the lookahead plus that
+// lag. The lookahead is at least limiterTaps samples.
+type Limiter struct {
+	rate   float64
+	phases [][]float32 // Interpolation taps for each fractional phase.
+
+	histL, histR []float32 // Recent input for the interpolator.
+	histPos      int
+
+	n int // Lookahead in samples. Zero until the first buffer.
+
+	delayL, delayR []float32 // Delay line for the signal.
+	delayPos       int
+
+	// Sliding window minimum of the required gain, as a monotonic queue
+	// of (gain, expiry) pairs in a ring buffer.
+	minGain   []float32
+	minExpiry []int64
+	minHead   int
+	minLen    int
+	count     int64
+
+	env float32 // Gain envelope before smoothing.
+
+	box    []float32 // Moving average of the envelope.
+	boxPos int
+	boxSum float64
+
+	gain float32 // Current output gain.
+}
+
+func NewLimiter(rate float64) *Limiter {
+	l := new(Limiter)
+	l.rate = rate
+
+	// Phase 0 is the sample itself, so only the fractional phases are
+	// needed.
+	half := limiterTaps / 2
+	beta := 6.0
+	for p := 1; p < limiterOversample; p++ {
+		mu := float64(p) / limiterOversample
+		taps := make([]float32, limiterTaps)
+		for i := range taps {
+			x := float64(i-half+1) - mu
+			w := x / float64(half)
+			win := 0.0
+			if w*w < 1 {
+				win = besselI0(beta*math.Sqrt(1-w*w)) / besselI0(beta)
+			}
+			taps[i] = float32(sinc(x) * win)
+		}
+		l.phases = append(l.phases, taps)
+	}
+
+	l.histL = make([]float32, limiterTaps)
+	l.histR = make([]float32, limiterTaps)
+
+	size := int(limiterMaxLookahead*rate) + 1
+	l.delayL = make([]float32, size+limiterTaps/2)
+	l.delayR = make([]float32, size+limiterTaps/2)
+	l.minGain = make([]float32, size)
+	l.minExpiry = make([]int64, size)
+	l.box = make([]float32, size)
+
+	l.env = 1
+	l.gain = 1
+
+	return l
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// reset: Clear the state for a lookahead of n samples.
+func (l *Limiter) reset(n int) {
+	l.n = n
+	for i := range l.delayL {
+		l.delayL[i] = 0
+		l.delayR[i] = 0
+	}
+	for i := range l.box {
+		l.box[i] = 1
+	}
+	l.delayPos = 0
+	l.minHead = 0
+	l.minLen = 0
+	l.env = 1
+	l.boxPos = 0
+	l.boxSum = float64(n)
+	l.gain = 1
+}
+
+// truePeak: Push a sample into the interpolator history and return the
+// estimated true peak at the sample limiterTaps/2 before it, and between
+// that sample and the next.
+func (l *Limiter) truePeak(L, R float32) float32 {
+	l.histL[l.histPos] = L
+	l.histR[l.histPos] = R
+	l.histPos++
+	if l.histPos == limiterTaps {
+		l.histPos = 0
+	}
+
+	// Interpolated points fall between the two samples at the center of
+	// the history. The first of those is compared directly.
+	c := l.histPos + limiterTaps/2 - 1
+	if c >= limiterTaps {
+		c -= limiterTaps
+	}
+	peak := abs32(l.histL[c])
+	if x := abs32(l.histR[c]); x > peak {
+		peak = x
+	}
+	for _, taps := range l.phases {
+		var yL, yR float32
+		j := l.histPos
+		for _, t := range taps {
+			yL += t * l.histL[j]
+			yR += t * l.histR[j]
+			if j++; j == limiterTaps {
+				j = 0
+			}
+		}
+		if x := abs32(yL); x > peak {
+			peak = x
+		}
+		if x := abs32(yR); x > peak {
+			peak = x
+		}
+	}
+	return peak
+}
+
+// delay: The signal delay in samples.
+func (l *Limiter) delay() int {
+	if l.n == 0 {
+		return 0
+	}
+	return l.n - 1 + limiterTaps/2
+}
+
+// Tail: True if the delay line holds samples that haven't been output yet.
+func (l *Limiter) Tail() bool {
+	size := len(l.delayL)
+	for k := 1; k <= l.delay(); k++ {
+		j := l.delayPos - k
+		if j < 0 {
+			j += size
+		}
+		if l.delayL[j] != 0 || l.delayR[j] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func abs32(x float32) float32 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// pushMin: Add a required gain to the sliding window, returning the minimum
+// over the last n samples.
+func (l *Limiter) pushMin(g float32) float32 {
+	size := len(l.minGain)
+	l.count++
+
+	// Drop expired entries from the front.
+	for l.minLen > 0 && l.minExpiry[l.minHead] <= l.count {
+		l.minHead++
+		if l.minHead == size {
+			l.minHead = 0
+		}
+		l.minLen--
+	}
+
+	// Drop entries from the back that can't be the minimum again.
+	for l.minLen > 0 {
+		back := (l.minHead + l.minLen - 1) % size
+		if l.minGain[back] < g {
+			break
+		}
+		l.minLen--
+	}
+
+	back := (l.minHead + l.minLen) % size
+	l.minGain[back] = g
+	l.minExpiry[back] = l.count + int64(l.n)
+	l.minLen++
+
+	return l.minGain[l.minHead]
+}
+
+// ----------------------------------------------------------------------------
+// Only called from the jack callback.
+
+// processLimiters: Limit each output pair. When the pairs are mixed down,
+// only the first has any signal.
+func (s *Sampler) processLimiters() {
+	minGain := float32(1)
+	for i, l := range s.limiters {
+		if i >= s.fxPairs() {
+			break
+		}
+		if gain := l.process(s.controls, &s.outs[i]); gain < minGain {
+			minGain = gain
+		}
+	}
+	s.reportGainReduction(minGain)
+}
+
+// process: Limit buf, returning the smallest gain applied.
+func (l *Limiter) process(c *Controls, buf *Sound) float32 {
+	thresh := float32(math.Pow(10, c.LimiterThresh/20))
+	minGain := float32(1)
+
+	if c.Limiter {
+		n := int(c.LimiterLookahead * l.rate)
+		if n < limiterTaps {
+			n = limiterTaps
+		} else if n > len(l.box)-1 {
+			n = len(l.box) - 1
+		}
+		if n != l.n {
+			l.reset(n)
+		}
+
+		release := float32(1)
+		if c.LimiterRelease > 0 {
+			release = float32(1 - math.Exp(-1/(c.LimiterRelease*l.rate)))
+		}
+
+		size := len(l.delayL)
+		for i := 0; i < buf.Len; i++ {
+			L, R := buf.L[i], buf.R[i]
+
+			need := float32(1)
+			if peak := l.truePeak(L, R); peak > thresh {
+				need = thresh / peak
+			}
+
+			hold := l.pushMin(need)
+			if hold < l.env {
+				l.env = hold
+			} else {
+				l.env += release * (hold - l.env)
+			}
+
+			l.boxSum += float64(l.env - l.box[l.boxPos])
+			l.box[l.boxPos] = l.env
+			if l.boxPos++; l.boxPos == l.n {
+				l.boxPos = 0
+			}
+			l.gain = float32(l.boxSum / float64(l.n))
+			if l.gain > 1 {
+				l.gain = 1
+			}
+			if l.gain < minGain {
+				minGain = l.gain
+			}
+
+			// Delay so the smoothed gain covers the peak.
+			l.delayL[l.delayPos] = L
+			l.delayR[l.delayPos] = R
+			j := l.delayPos - l.delay()
+			if j < 0 {
+				j += size
+			}
+			if l.delayPos++; l.delayPos == size {
+				l.delayPos = 0
+			}
+
+			buf.L[i] = l.delayL[j] * l.gain
+			buf.R[i] = l.delayR[j] * l.gain
+		}
+	} else if l.n != 0 {
+		l.n = 0
+	}
+
+	if c.SoftClip {
+		for i := 0; i < buf.Len; i++ {
+			buf.L[i] = softClip(buf.L[i], thresh)
+			buf.R[i] = softClip(buf.R[i], thresh)
+		}
+	}
+
+	return minGain
+}
+
+// softClip: Pass x unchanged below limiterSoftKnee of the ceiling, and
+// approach the ceiling smoothly above it.
+func softClip(x, ceiling float32) float32 {
+	knee := limiterSoftKnee * ceiling
+	ax := abs32(x)
+	if ax <= knee {
+		return x
+	}
+	y := knee + (ceiling-knee)*
+		float32(math.Tanh(float64((ax-knee)/(ceiling-knee))))
+	if x < 0 {
+		return -y
+	}
+	return y
+}
+
+// reportGainReduction: Record the largest gain reduction since the last
+// report.
+func (s *Sampler) reportGainReduction(gain float32) {
+	if gain < limiterFloor {
+		gain = limiterFloor
+	}
+	db := -20 * math.Log10(float64(gain))
+	for {
+		old := atomic.LoadUint64(&s.gainReduction)
+		if db <= math.Float64frombits(old) {
+			return
+		}
+		bits := math.Float64bits(db)
+		if atomic.CompareAndSwapUint64(&s.gainReduction, old, bits) {
+			return
+		}
+	}
+}
+
+// GainReduction: The largest limiter gain reduction in dB since the last
+// call. This may be called from any goroutine.
+func (s *Sampler) GainReduction() float64 {
+	return math.Float64frombits(atomic.SwapUint64(&s.gainReduction, 0))
+}
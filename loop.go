@@ -0,0 +1,229 @@
+package jlsampler
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+)
+
+// Loop modes. See SampleLoop and Controls.LoopMode.
+const (
+	LoopNone         int8 = iota // Play through without looping.
+	LoopForward                  // Jump from the loop end back to the start.
+	LoopPingPong                 // Alternate direction at each loop point.
+	LoopUntilRelease             // Loop forward until the key is released.
+	numLoopModes
+)
+
+var loopModeNames = []string{"none", "forward", "ping-pong", "until-release"}
+
+func loopModeName(mode int8) string {
+	if mode < 0 || mode >= numLoopModes {
+		return "sample"
+	}
+	return loopModeNames[mode]
+}
+
+// ----------------------------------------------------------------------------
+// SampleLoop: A sustain loop. The loop covers indices Start to End-1.
+type SampleLoop struct {
+	Start int
+	End   int
+	Mode  int8
+}
+
+// valid: True if the loop fits in a sample of the given length.
+func (l *SampleLoop) valid(length int) bool {
+	return l.Start >= 0 && l.End > l.Start+1 && l.End <= length
+}
+
+// scaled: The loop with its points scaled by ratio, for stretched or
+// resampled samples of the given length. Returns nil if the loop no longer
+// fits.
+func (l *SampleLoop) scaled(ratio float64, length int) *SampleLoop {
+	if l == nil {
+		return nil
+	}
+	l2 := &SampleLoop{
+		Start: int(float64(l.Start)*ratio + 0.5),
+		End:   int(float64(l.End)*ratio + 0.5),
+		Mode:  l.Mode,
+	}
+	if l2.End > length {
+		l2.End = length
+	}
+	if !l2.valid(length) {
+		return nil
+	}
+	return l2
+}
+
+// parseSmplChunk: Return the first loop from a RIFF smpl chunk, or nil.
+// Loop type 1 is ping-pong. Other types are played forward.
+func parseSmplChunk(b []byte) *SampleLoop {
+	if len(b) < 36 {
+		return nil
+	}
+	le := binary.LittleEndian
+	if le.Uint32(b[28:32]) == 0 || len(b) < 36+24 {
+		return nil
+	}
+
+	lp := b[36 : 36+24]
+	loop := &SampleLoop{
+		Start: int(le.Uint32(lp[8:12])),
+		End:   int(le.Uint32(lp[12:16])) + 1, // The end is inclusive.
+		Mode:  LoopForward,
+	}
+	if le.Uint32(lp[4:8]) == 1 {
+		loop.Mode = LoopPingPong
+	}
+	return loop
+}
+
+// flacLoop: Return the loop from flac metadata, or nil. Loops are read from
+// RIFF smpl chunks stored in "riff" application blocks, as written by
+// flac --keep-foreign-metadata.
+func flacLoop(meta []FlacMetadata) *SampleLoop {
+	for _, m := range meta {
+		if m.Type != 2 || len(m.Data) < 12 || string(m.Data[0:4]) != "riff" {
+			continue
+		}
+		chunk := m.Data[4:]
+		if string(chunk[0:4]) != "smpl" {
+			continue
+		}
+		size := int(binary.LittleEndian.Uint32(chunk[4:8]))
+		if 8+size > len(chunk) {
+			size = len(chunk) - 8
+		}
+		if loop := parseSmplChunk(chunk[8 : 8+size]); loop != nil {
+			return loop
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// LoopFile: Optional loop points read from loops.js. The file maps sample
+// paths to loops, and overrides loops found in the sample files. End is
+// exclusive and Mode defaults to LoopForward. For example:
+//
+//	{"samples/on-060-001-001.flac": {"Start": 1000, "End": 48000, "Mode": 2}}
+type LoopFile struct {
+	vals map[string]loopFileEntry
+}
+
+type loopFileEntry struct {
+	Start int
+	End   int
+	Mode  *int8
+}
+
+func LoadLoopFile() *LoopFile {
+	lf := new(LoopFile)
+
+	f, err := os.Open("loops.js")
+	if err != nil {
+		// The file is optional.
+		return lf
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	if err = decoder.Decode(&lf.vals); err != nil {
+		Println("Error decoding loop file:", err)
+	}
+
+	return lf
+}
+
+// GetLoop: Return the loop for the given file, or nil.
+func (lf *LoopFile) GetLoop(filename string) *SampleLoop {
+	entry, ok := lf.vals[filename]
+	if !ok {
+		return nil
+	}
+	loop := &SampleLoop{Start: entry.Start, End: entry.End, Mode: LoopForward}
+	if entry.Mode != nil {
+		loop.Mode = *entry.Mode
+	}
+	return loop
+}
+
+// ----------------------------------------------------------------------------
+// Loop playback. Only called from the jack callback.
+
+// loopMode: The loop mode for the voice, taking Controls.LoopMode into
+// account.
+func (ps *PlayingSample) loopMode() int8 {
+	if ps.loop == nil {
+		return LoopNone
+	}
+	if mode := ps.controls.LoopMode; mode >= 0 && mode < numLoopModes {
+		return mode
+	}
+	return ps.loop.Mode
+}
+
+// releaseLoop: Called when the key is released. Voices looping until
+// release play through to the end of the sample.
+func (ps *PlayingSample) releaseLoop() {
+	if ps.loopMode() == LoopUntilRelease {
+		ps.loop = nil
+	}
+}
+
+// loopXfade: Return the weight of the crossfade source for forward loops
+// at the current index, or 0 outside the crossfade. The crossfade blends the
+// end of the loop with the samples before the loop start, so that the jump
+// back to the start is seamless.
+func (ps *PlayingSample) loopXfade() float32 {
+	mode := ps.loopMode()
+	if mode != LoopForward && mode != LoopUntilRelease {
+		return 0
+	}
+
+	loop := ps.loop
+	xfade := ps.controls.NLoopXfade
+	if max := float32(loop.Start); xfade > max {
+		xfade = max
+	}
+	if max := float32(loop.End - loop.Start); xfade > max {
+		xfade = max
+	}
+	if xfade < 1 {
+		return 0
+	}
+
+	x := float32(ps.idx - (float64(loop.End) - float64(xfade)))
+	if x <= 0 {
+		return 0
+	}
+	return x / xfade
+}
+
+// stepLoop: Wrap the index at the loop points.
+func (ps *PlayingSample) stepLoop() {
+	loop := ps.loop
+	start, end := float64(loop.Start), float64(loop.End)
+
+	switch ps.loopMode() {
+	case LoopForward, LoopUntilRelease:
+		for ps.idx >= end {
+			ps.idx -= end - start
+		}
+
+	case LoopPingPong:
+		// Reflect at the last sample of the loop and at the first.
+		last := end - 1
+		if ps.idx > last {
+			ps.idx = 2*last - ps.idx
+			ps.dir = -1
+		}
+		if ps.dir < 0 && ps.idx < start {
+			ps.idx = 2*start - ps.idx
+			ps.dir = 1
+		}
+	}
+}
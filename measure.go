@@ -0,0 +1,115 @@
+package jlsampler
+
+import (
+	"flag"
+	"fmt"
+	"math"
+)
+
+// Pitch ratios to measure: a semitone up and down, 44.1k played at 48k and
+// vice versa, a fifth up and an octave down.
+var measureRatios = []float64{
+	math.Pow(2, 1.0/12), math.Pow(2, -1.0/12),
+	44100.0 / 48000.0, 48000.0 / 44100.0,
+	1.5, 0.5,
+}
+
+const (
+	measureLen    = 1 << 14 // Output samples analysed for each measurement.
+	measureMargin = 64      // Input samples skipped at each end.
+)
+
+// ----------------------------------------------------------------------------
+// RunMeasure: Report distortion for each interpolation kernel.
+// Usage: jlsampler measure [-rate N] [-low Hz] [-high Hz]
+//
+// The low tone measures THD+N: everything that isn't the resampled tone,
+// relative to the tone. The high tone measures aliasing in the same way. If
+// the resampled high tone is above nyquist, the whole output is aliasing.
+func RunMeasure(args []string) {
+	flags := flag.NewFlagSet("measure", flag.ContinueOnError)
+	rate := flags.Int("rate", sampleRate, "Sample rate of the test tones.")
+	low := flags.Float64("low", 1000, "Frequency of the THD+N test tone.")
+	high := flags.Float64("high", 19200, "Frequency of the aliasing test tone.")
+
+	if err := flags.Parse(args); err != nil {
+		return
+	}
+
+	if *rate <= 0 || *low <= 0 || *high <= 0 {
+		Println("Error: rate and frequencies must be positive.")
+		return
+	}
+
+	lowSamp := sineSample(*low / float64(*rate))
+	highSamp := sineSample(*high / float64(*rate))
+
+	fmt.Printf("Test tones %g Hz and %g Hz at %d Hz. Levels in dB.\n\n",
+		*low, *high, *rate)
+	fmt.Printf("%-8s %8s %10s %10s\n", "kernel", "ratio", "thd+n", "aliasing")
+
+	for k := InterpKernel(0); k < numKernels; k++ {
+		for _, ratio := range measureRatios {
+			fmt.Printf("%-8s %8.4f %10.1f %10.1f\n", k, ratio,
+				measureKernel(lowSamp, k, ratio, *low/float64(*rate)),
+				measureKernel(highSamp, k, ratio, *high/float64(*rate)))
+		}
+		fmt.Println()
+	}
+}
+
+// sineSample: A mono sample with a unit sine of the given frequency in
+// cycles per sample, long enough to play at any of the measureRatios.
+func sineSample(freq float64) *Sample {
+	n := 2*measureMargin + measureLen*2
+	L := make([]float32, n)
+	for i := range L {
+		L[i] = float32(math.Sin(2 * math.Pi * freq * float64(i)))
+	}
+	return NewSampleFromArrays(L, nil, 32)
+}
+
+// measureKernel: Play s at the given pitch ratio and return the power of
+// the residual after removing the expected tone, relative to a unit sine.
+func measureKernel(s *Sample, k InterpKernel, ratio, freq float64) float64 {
+	// The position is kept in double precision so that the result doesn't
+	// include jitter from rounding the index.
+	y := make([]float64, measureLen)
+	for i := range y {
+		idx := measureMargin + float64(i)*ratio
+		iIdx := int(idx)
+		L, _ := s.interpAt(iIdx, float32(idx-float64(iIdx)), k)
+		y[i] = float64(L)
+	}
+
+	// Least squares fit of the expected tone, allowing any gain and phase.
+	// Above nyquist the expected output is silence.
+	w := 2 * math.Pi * freq * ratio
+	if freq*ratio < 0.5 {
+		var cc, ss, cs, yc, ys float64
+		for i := range y {
+			c := math.Cos(w * float64(i))
+			sn := math.Sin(w * float64(i))
+			cc += c * c
+			ss += sn * sn
+			cs += c * sn
+			yc += y[i] * c
+			ys += y[i] * sn
+		}
+		det := cc*ss - cs*cs
+		a := (yc*ss - ys*cs) / det
+		b := (ys*cc - yc*cs) / det
+		for i := range y {
+			y[i] -= a*math.Cos(w*float64(i)) + b*math.Sin(w*float64(i))
+		}
+	}
+
+	power := 0.0
+	for _, v := range y {
+		power += v * v
+	}
+	power /= float64(len(y))
+
+	// A unit sine has power 1/2.
+	return 10 * math.Log10(power/0.5+1e-20)
+}
@@ -0,0 +1,259 @@
+package jlsampler
+
+import (
+	"errors"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+type MidiEventType int8
+
+const (
+	MidiNoteOn MidiEventType = iota
+	MidiNoteOff
+	MidiController
+	MidiPitchBend
+	MidiAftertouch     // Channel pressure.
+	MidiPolyAftertouch // Per-key pressure.
+	MidiProgramChange
+	MidiSysEx
+)
+
+const midiMaxSysEx = 1024 // Longer system exclusive messages are dropped.
+
+// MidiEvent: A midi event produced by a MidiSource. Value is scaled to the
+// range 0 to 1, except for pitch bend which is -1 to 1.
+type MidiEvent struct {
+	Type    MidiEventType
+	Channel uint8
+	Note    int8  // Note for note and poly aftertouch events.
+	Control int32 // Controller or program number.
+	Value   float64
+	Data    []byte // System exclusive message, from F0 to F7.
+}
+
+// MidiSource: A source of midi events.
+type MidiSource interface {
+	// Run: Read events, passing each one to fn. Returns when the source is
+	// exhausted or fails.
+	Run(fn func(MidiEvent)) error
+}
+
+// NewMidiSource: Create the source selected by config.MidiSource. One of
+// "alsa" (the default), "raw:<path>" or "replay:<path>".
+func NewMidiSource(name string, config *Config) (MidiSource, error) {
+	src := config.MidiSource
+
+	switch {
+	case src == "" || src == "alsa":
+		return NewMidiListener(name, config.MidiIn)
+	case strings.HasPrefix(src, "raw:"):
+		return NewRawMidiSource(src[len("raw:"):]), nil
+	case strings.HasPrefix(src, "replay:"):
+		return NewReplaySource(src[len("replay:"):]), nil
+	}
+
+	return nil, errors.New("Unknown midi source: " + src)
+}
+
+// ----------------------------------------------------------------------------
+// decodeMidiMessage: Decode a channel message given its status and data
+// bytes. Returns false for messages that aren't handled.
+func decodeMidiMessage(status byte, d []byte) (MidiEvent, bool) {
+	var ev MidiEvent
+	ev.Channel = status & 0x0f
+
+	switch status & 0xf0 {
+	case 0x90:
+		ev.Note = int8(d[0])
+		ev.Value = float64(d[1]) / 127.0
+		if d[1] != 0 {
+			ev.Type = MidiNoteOn
+		} else {
+			ev.Type = MidiNoteOff
+		}
+
+	case 0x80:
+		ev.Type = MidiNoteOff
+		ev.Note = int8(d[0])
+		ev.Value = float64(d[1]) / 127.0
+
+	case 0xa0:
+		ev.Type = MidiPolyAftertouch
+		ev.Note = int8(d[0])
+		ev.Value = float64(d[1]) / 127.0
+
+	case 0xb0:
+		ev.Type = MidiController
+		ev.Control = int32(d[0])
+		ev.Value = float64(d[1]) / 127.0
+
+	case 0xc0:
+		ev.Type = MidiProgramChange
+		ev.Control = int32(d[0])
+
+	case 0xd0:
+		ev.Type = MidiAftertouch
+		ev.Value = float64(d[0]) / 127.0
+
+	case 0xe0:
+		ev.Type = MidiPitchBend
+		ev.Value = pitchBendValue((int32(d[0]) | int32(d[1])<<7) - 8192)
+
+	default:
+		return ev, false
+	}
+
+	return ev, true
+}
+
+// midiDataLen: The number of data bytes following a channel status byte.
+func midiDataLen(status byte) int {
+	switch status & 0xf0 {
+	case 0xc0, 0xd0:
+		return 1
+	}
+	return 2
+}
+
+// ----------------------------------------------------------------------------
+// sysExBuffer: Collects a system exclusive message, which may arrive in
+// pieces.
+type sysExBuffer struct {
+	data []byte
+	on   bool // True while inside a message.
+}
+
+// push: Add a byte. Returns the message and true when it's complete. Any
+// status byte other than F7 ends the message without completing it.
+func (b *sysExBuffer) push(c byte) ([]byte, bool) {
+	switch {
+	case c == 0xf0:
+		b.data = append(b.data[:0], c)
+		b.on = true
+
+	case !b.on:
+
+	case c == 0xf7:
+		b.on = false
+		msg := make([]byte, len(b.data)+1)
+		copy(msg, b.data)
+		msg[len(b.data)] = c
+		return msg, true
+
+	case c >= 0x80 || len(b.data) >= midiMaxSysEx:
+		b.on = false
+
+	default:
+		b.data = append(b.data, c)
+	}
+
+	return nil, false
+}
+
+// pushAll: Add the bytes in data, returning the last message completed.
+func (b *sysExBuffer) pushAll(data []byte) ([]byte, bool) {
+	var msg []byte
+	done := false
+	for _, c := range data {
+		if m, ok := b.push(c); ok {
+			msg, done = m, true
+		}
+	}
+	return msg, done
+}
+
+// ----------------------------------------------------------------------------
+// MidiParser: Parse a raw midi byte stream, including running status.
+// System exclusive messages are returned whole. Other system messages are
+// skipped.
+type MidiParser struct {
+	status byte        // Current running status, or 0 if none.
+	data   [2]byte     // Data bytes received for the current message.
+	nData  int         // Number of data bytes received.
+	sysex  sysExBuffer // The current system exclusive message.
+}
+
+// Parse: Feed a byte to the parser. Returns an event and true when a
+// complete message has been received.
+func (p *MidiParser) Parse(b byte) (MidiEvent, bool) {
+	switch {
+	case b >= 0xf8:
+		// Real-time messages may appear anywhere and don't affect
+		// running status.
+		return MidiEvent{}, false
+
+	case b == 0xf0:
+		p.sysex.push(b)
+		p.status = 0
+		return MidiEvent{}, false
+
+	case b == 0xf7:
+		if msg, ok := p.sysex.push(b); ok {
+			return MidiEvent{Type: MidiSysEx, Data: msg}, true
+		}
+		return MidiEvent{}, false
+
+	case b >= 0xf1:
+		// System common messages cancel running status.
+		p.sysex.push(b)
+		p.status = 0
+		return MidiEvent{}, false
+
+	case b >= 0x80:
+		p.sysex.push(b)
+		p.status = b
+		p.nData = 0
+		return MidiEvent{}, false
+	}
+
+	// Data byte.
+	if p.sysex.on {
+		p.sysex.push(b)
+		return MidiEvent{}, false
+	}
+	if p.status == 0 {
+		return MidiEvent{}, false
+	}
+
+	p.data[p.nData] = b
+	p.nData++
+
+	if p.nData < midiDataLen(p.status) {
+		return MidiEvent{}, false
+	}
+
+	p.nData = 0
+	return decodeMidiMessage(p.status, p.data[:])
+}
+
+// ----------------------------------------------------------------------------
+// MidiEvent: Send a midi event to the sampler.
+func (s *Sampler) MidiEvent(ev MidiEvent) {
+	if sev, ok := samplerEvent(ev); ok {
+		s.pushEvent(sev)
+	}
+}
+
+// samplerEvent: Convert a midi event to a sampler event. Returns false for
+// events the sampler doesn't use.
+func samplerEvent(ev MidiEvent) (Event, bool) {
+	switch ev.Type {
+	case MidiNoteOn:
+		return Event{Type: EventNoteOn, Note: ev.Note, Value: ev.Value}, true
+	case MidiNoteOff:
+		return Event{Type: EventNoteOff, Note: ev.Note, Value: ev.Value}, true
+	case MidiController:
+		return Event{
+			Type: EventController, Control: ev.Control, Value: ev.Value}, true
+	case MidiPitchBend:
+		return Event{Type: EventPitchBend, Value: ev.Value}, true
+	case MidiProgramChange:
+		return Event{Type: EventProgramChange, Control: ev.Control}, true
+	case MidiSysEx:
+		if t, ok := parseMts(ev.Data); ok {
+			return Event{Type: EventTuning, Tuning: t}, true
+		}
+	}
+	return Event{}, false
+}
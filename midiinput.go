@@ -0,0 +1,53 @@
+package jlsampler
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// midiInput: An alsa sequencer port to connect to, given either as a
+// client:port address or a substring of the client or port name.
+type midiInput struct {
+	client int    // Client number, or -1 to match by name.
+	port   int    // Port number, or -1 to match by name.
+	name   string // Name substring.
+}
+
+// parseMidiInputs: Parse a comma separated list of midi inputs.
+func parseMidiInputs(midiIn string) []midiInput {
+	var inputs []midiInput
+
+	for _, item := range strings.Split(midiIn, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+
+		in := midiInput{client: -1, port: -1, name: item}
+
+		if sp := strings.Split(item, ":"); len(sp) == 2 {
+			client, err1 := strconv.Atoi(sp[0])
+			port, err2 := strconv.Atoi(sp[1])
+			if err1 == nil && err2 == nil {
+				in.client = client
+				in.port = port
+			}
+		}
+
+		inputs = append(inputs, in)
+	}
+
+	return inputs
+}
+
+// matches: Return true if the input refers to the given port.
+func (in midiInput) matches(
+	client, port int, clientName, portName string) bool {
+
+	if in.client >= 0 {
+		return in.client == client && in.port == port
+	}
+	return strings.Contains(clientName, in.name) ||
+		strings.Contains(portName, in.name)
+}
@@ -7,25 +7,27 @@ import "C"
 import (
 	"encoding/binary"
 	"errors"
-	"fmt"
-	"os/exec"
+	"unsafe"
 )
 
 // ----------------------------------------------------------------------------
+// MidiListener: A MidiSource reading from the alsa sequencer.
 type MidiListener struct {
-	sampler *Sampler
-	handle  *C.snd_seq_t // Handle for midi sequencer.
+	handle    *C.snd_seq_t // Handle for midi sequencer.
+	clientNum int          // Our client number.
+	portNum   int          // Our input port number.
+	inputs    []midiInput  // Ports to connect to.
+	sysex     sysExBuffer  // Long messages arrive in several events.
 }
 
 /* NewMidiListener
- * name     : The name of the device.
- * midiPort : An incoming midi port to connect to.
+ * name   : The name of the device.
+ * midiIn : Comma separated list of incoming midi ports to connect to. Each
+ *          is either a client:port address or a client or port name
+ *          substring.
  */
-func NewMidiListener(
-	sampler *Sampler, name, midiIn string) (*MidiListener, error) {
-
+func NewMidiListener(name, midiIn string) (*MidiListener, error) {
 	ml := new(MidiListener)
-	ml.sampler = sampler
 
 	// Open the midi device.
 	openIn := C.int(C.SND_SEQ_OPEN_INPUT)
@@ -34,7 +36,7 @@ func NewMidiListener(
 		return nil, errors.New("Failed to open midi device.")
 	}
 
-	clientNum := int(C.snd_seq_client_id(ml.handle))
+	ml.clientNum = int(C.snd_seq_client_id(ml.handle))
 
 	// Give the client a name.
 	status = int(C.snd_seq_set_client_name(ml.handle, C.CString(name)))
@@ -46,41 +48,167 @@ func NewMidiListener(
 	caps := C.uint(C.SND_SEQ_PORT_CAP_WRITE | C.SND_SEQ_PORT_CAP_SUBS_WRITE)
 	type_ := C.uint(C.SND_SEQ_PORT_TYPE_MIDI_GM)
 
-	portNum := int(
+	ml.portNum = int(
 		C.snd_seq_create_simple_port(ml.handle, C.CString(name), caps, type_))
-	if portNum < 0 {
+	if ml.portNum < 0 {
 		return nil, errors.New("Failed to create port.")
 	}
 
-	// Call aconnect to connect midi port.
-	if len(midiIn) != 0 {
-		go func() {
-			midiPort := fmt.Sprintf("%d:%d", clientNum, portNum)
-			cmd := "aconnect " + midiIn + " " + midiPort
-			err := exec.Command("sh", "-c", cmd).Run()
-			if err != nil {
-				Println("Failed in call to aconnect:", cmd, err)
+	// Listen for port announcements so inputs can be reconnected when
+	// they're plugged in.
+	status = int(C.snd_seq_connect_from(
+		ml.handle, C.int(ml.portNum),
+		C.SND_SEQ_CLIENT_SYSTEM, C.SND_SEQ_PORT_SYSTEM_ANNOUNCE))
+	if status < 0 {
+		Println("Failed to subscribe to port announcements.")
+	}
+
+	// Connect to the existing input ports.
+	ml.inputs = parseMidiInputs(midiIn)
+	connected := make([]bool, len(ml.inputs))
+
+	ml.forEachPort(func(client, port int, clientName, portName string) {
+		matched := false
+		for i, in := range ml.inputs {
+			if in.matches(client, port, clientName, portName) {
+				connected[i] = true
+				matched = true
 			}
-		}()
+		}
+		if matched {
+			ml.connect(client, port, clientName, portName)
+		}
+	})
+
+	for i, in := range ml.inputs {
+		if !connected[i] {
+			Println("Midi input not found, waiting for it:", in.name)
+		}
 	}
 
 	return ml, nil
 }
 
+// forEachPort: Call fn for each readable sequencer port, other than our
+// own and the system ports.
+func (ml *MidiListener) forEachPort(
+	fn func(client, port int, clientName, portName string)) {
+
+	var cinfo *C.snd_seq_client_info_t
+	var pinfo *C.snd_seq_port_info_t
+
+	C.snd_seq_client_info_malloc(&cinfo)
+	defer C.snd_seq_client_info_free(cinfo)
+	C.snd_seq_port_info_malloc(&pinfo)
+	defer C.snd_seq_port_info_free(pinfo)
+
+	C.snd_seq_client_info_set_client(cinfo, -1)
+	for C.snd_seq_query_next_client(ml.handle, cinfo) >= 0 {
+		client := C.snd_seq_client_info_get_client(cinfo)
+		if client == C.SND_SEQ_CLIENT_SYSTEM || int(client) == ml.clientNum {
+			continue
+		}
+		clientName := C.GoString(C.snd_seq_client_info_get_name(cinfo))
+
+		C.snd_seq_port_info_set_client(pinfo, client)
+		C.snd_seq_port_info_set_port(pinfo, -1)
+		for C.snd_seq_query_next_port(ml.handle, pinfo) >= 0 {
+			if !portReadable(pinfo) {
+				continue
+			}
+			fn(int(client),
+				int(C.snd_seq_port_info_get_port(pinfo)),
+				clientName,
+				C.GoString(C.snd_seq_port_info_get_name(pinfo)))
+		}
+	}
+}
+
+func portReadable(pinfo *C.snd_seq_port_info_t) bool {
+	caps := C.snd_seq_port_info_get_capability(pinfo)
+	want := C.uint(C.SND_SEQ_PORT_CAP_READ | C.SND_SEQ_PORT_CAP_SUBS_READ)
+	return caps&want == want
+}
+
+// connect: Subscribe our port to the given port.
+func (ml *MidiListener) connect(
+	client, port int, clientName, portName string) bool {
+
+	status := int(C.snd_seq_connect_from(
+		ml.handle, C.int(ml.portNum), C.int(client), C.int(port)))
+	if status < 0 {
+		Println("Failed to connect midi input:", clientName, portName)
+		return false
+	}
+	Println("Connected midi input:", clientName, "-", portName)
+	return true
+}
+
+// portStarted: Connect a newly announced port if it matches an input.
+func (ml *MidiListener) portStarted(client, port int) {
+	if client == ml.clientNum {
+		return
+	}
+
+	var cinfo *C.snd_seq_client_info_t
+	var pinfo *C.snd_seq_port_info_t
+
+	C.snd_seq_client_info_malloc(&cinfo)
+	defer C.snd_seq_client_info_free(cinfo)
+	C.snd_seq_port_info_malloc(&pinfo)
+	defer C.snd_seq_port_info_free(pinfo)
+
+	if C.snd_seq_get_any_client_info(ml.handle, C.int(client), cinfo) < 0 {
+		return
+	}
+	status := C.snd_seq_get_any_port_info(
+		ml.handle, C.int(client), C.int(port), pinfo)
+	if status < 0 || !portReadable(pinfo) {
+		return
+	}
+
+	clientName := C.GoString(C.snd_seq_client_info_get_name(cinfo))
+	portName := C.GoString(C.snd_seq_port_info_get_name(pinfo))
+
+	for _, in := range ml.inputs {
+		if in.matches(client, port, clientName, portName) {
+			ml.connect(client, port, clientName, portName)
+			return
+		}
+	}
+}
+
 func noteAndValue(ev *C.snd_seq_event_t) (int8, float64) {
 	note := int8(ev.data[1])
 	value := float64(ev.data[2]) / 127.0
 	return note, value
 }
 
+// ctrlParamAndValue: Return the param and value of a control event.
+func ctrlParamAndValue(ev *C.snd_seq_event_t) (int32, int32) {
+	param := int32(binary.LittleEndian.Uint32(ev.data[4:8]))
+	value := int32(binary.LittleEndian.Uint32(ev.data[8:12]))
+	return param, value
+}
+
+// sysExData: The data of a system exclusive event. The event holds a length
+// and a pointer, packed.
+func sysExData(ev *C.snd_seq_event_t) []byte {
+	n := binary.LittleEndian.Uint32(ev.data[0:4])
+	ptr := *(*unsafe.Pointer)(unsafe.Pointer(&ev.data[4]))
+	if n == 0 || ptr == nil {
+		return nil
+	}
+	return C.GoBytes(ptr, C.int(n))
+}
+
 /* Run
- * Read incoming midi events and send them to the sampler.
+ * Read incoming midi events and pass them to fn.
  */
-func (ml *MidiListener) Run() {
+func (ml *MidiListener) Run(fn func(MidiEvent)) error {
 	var ev *C.snd_seq_event_t
 	var status int
-	var note int8
-	var value float64
+	var mev MidiEvent
 
 	for {
 		status = int(C.snd_seq_event_input(ml.handle, &ev))
@@ -89,28 +217,65 @@ func (ml *MidiListener) Run() {
 			continue
 		}
 
+		mev = MidiEvent{Channel: uint8(ev.data[0])}
+
 		switch ev._type {
 
 		case C.SND_SEQ_EVENT_NOTEON:
-			note, value = noteAndValue(ev)
+			mev.Note, mev.Value = noteAndValue(ev)
 			if ev.data[2] != 0 {
-				ml.sampler.NoteOnEvent(note, value)
+				mev.Type = MidiNoteOn
 			} else {
-				ml.sampler.NoteOffEvent(note, value)
+				mev.Type = MidiNoteOff
 			}
 
 		case C.SND_SEQ_EVENT_NOTEOFF:
-			note, value = noteAndValue(ev)
-			ml.sampler.NoteOffEvent(note, value)
+			mev.Type = MidiNoteOff
+			mev.Note, mev.Value = noteAndValue(ev)
+
+		case C.SND_SEQ_EVENT_KEYPRESS:
+			mev.Type = MidiPolyAftertouch
+			mev.Note, mev.Value = noteAndValue(ev)
 
 		case C.SND_SEQ_EVENT_CONTROLLER:
-			ml.sampler.ControllerEvent(
-				int32(binary.LittleEndian.Uint32(ev.data[4:8])),
-				float64(binary.LittleEndian.Uint32(ev.data[8:12]))/127)
+			param, value := ctrlParamAndValue(ev)
+			mev.Type = MidiController
+			mev.Control = param
+			mev.Value = float64(value) / 127
+
+		case C.SND_SEQ_EVENT_CHANPRESS:
+			_, value := ctrlParamAndValue(ev)
+			mev.Type = MidiAftertouch
+			mev.Value = float64(value) / 127
+
+		case C.SND_SEQ_EVENT_PGMCHANGE:
+			_, value := ctrlParamAndValue(ev)
+			mev.Type = MidiProgramChange
+			mev.Control = value
+
+		case C.SND_SEQ_EVENT_SYSEX:
+			msg, ok := ml.sysex.pushAll(sysExData(ev))
+			if !ok {
+				continue
+			}
+			mev.Type = MidiSysEx
+			mev.Data = msg
+
+		case C.SND_SEQ_EVENT_PORT_START:
+			// The address of the new port is in the event data.
+			ml.portStarted(int(ev.data[0]), int(ev.data[1]))
+			continue
 
 		case C.SND_SEQ_EVENT_PITCHBEND:
-			ml.sampler.PitchBendEvent(
-				float64(binary.LittleEndian.Uint32(ev.data[8:12])) / 8192.0)
+			// The value is a signed 14-bit number from -8192 to 8191.
+			_, bend := ctrlParamAndValue(ev)
+			mev.Type = MidiPitchBend
+			mev.Value = pitchBendValue(bend)
+
+		default:
+			continue
 		}
+
+		fn(mev)
 	}
 }
@@ -0,0 +1,197 @@
+package jlsampler
+
+import (
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// MIDI Tuning Standard. The supported messages are:
+//
+//	F0 7E dd 08 01 tt <name> [xx yy zz]*128 cs F7     Bulk dump
+//	F0 7E dd 08 04 bb tt <name> [xx yy zz]*128 cs F7  Bulk dump with bank
+//	F0 7F dd 08 02 tt ll [kk xx yy zz]*ll F7          Single note change
+//	F0 7x dd 08 07 bb tt ll [kk xx yy zz]*ll F7       Single note with bank
+//	F0 7x dd 08 08 ff gg hh [ss]*12 F7                Scale/octave, 1 byte
+//	F0 7x dd 08 09 ff gg hh [ss tt]*12 F7             Scale/octave, 2 bytes
+//
+// The sampler has a single MTS tuning. Device IDs, tuning programs, banks and
+// channel masks are ignored, and so are bulk dump checksums, which many
+// senders get wrong. Real-time (7F) messages also retune sounding voices.
+//
+// MTS tuning overrides the Scala tuning for the keys it sets, until the
+// MtsReset control is set.
+
+// MtsTuning: Per-key pitch offsets in semitones from equal temperament, for
+// the keys that are set.
+type MtsTuning struct {
+	offsets  [128]float64
+	set      [128]bool
+	realTime bool // Retune sounding voices.
+}
+
+// Offset: The offset for the key in semitones, and false if it isn't set.
+func (t *MtsTuning) Offset(key int) (float64, bool) {
+	if key < 0 || key > 127 || !t.set[key] {
+		return 0, false
+	}
+	return t.offsets[key], true
+}
+
+// active: True if any key is set.
+func (t *MtsTuning) active() bool {
+	for _, set := range t.set {
+		if set {
+			return true
+		}
+	}
+	return false
+}
+
+// merge: Apply the keys set in u.
+func (t *MtsTuning) merge(u *MtsTuning) {
+	for key, set := range u.set {
+		if set {
+			t.offsets[key] = u.offsets[key]
+			t.set[key] = true
+		}
+	}
+}
+
+// setFreq: Set a key from a three byte MTS frequency: the semitone and a
+// 14-bit fraction of a semitone. 7F 7F 7F means no change.
+func (t *MtsTuning) setFreq(key int, b []byte) {
+	if key < 0 || key > 127 || (b[0] == 0x7f && b[1] == 0x7f && b[2] == 0x7f) {
+		return
+	}
+	frac := float64(int(b[1])<<7|int(b[2])) / 16384
+	t.offsets[key] = float64(b[0]) + frac - float64(key)
+	t.set[key] = true
+}
+
+// setOctave: Set every key from offsets in cents for each pitch class,
+// starting at C.
+func (t *MtsTuning) setOctave(cents []float64) {
+	for key := range t.offsets {
+		t.offsets[key] = cents[key%12] / 100
+		t.set[key] = true
+	}
+}
+
+// parseMts: Parse an MTS message, from F0 to F7. Returns false for other
+// system exclusive messages and malformed MTS messages.
+func parseMts(msg []byte) (*MtsTuning, bool) {
+	n := len(msg)
+	if n < 6 || msg[0] != 0xf0 || msg[n-1] != 0xf7 || msg[3] != 0x08 {
+		return nil, false
+	}
+	if msg[1] != 0x7e && msg[1] != 0x7f {
+		return nil, false
+	}
+
+	t := new(MtsTuning)
+	t.realTime = msg[1] == 0x7f
+	d := msg[5 : n-1]
+
+	switch msg[4] {
+	case 0x01, 0x04:
+		// Bulk dump. The bank variant has an extra byte.
+		if msg[4] == 0x04 {
+			if len(d) == 0 {
+				return nil, false
+			}
+			d = d[1:]
+		}
+		if len(d) < 1+16+3*128 {
+			Println("Truncated MTS bulk dump.")
+			return nil, false
+		}
+		name := strings.TrimSpace(string(d[1:17]))
+		d = d[17:]
+		for key := 0; key < 128; key++ {
+			t.setFreq(key, d[3*key:3*key+3])
+		}
+		Println("MTS bulk dump:", name)
+
+	case 0x02, 0x07:
+		// Single note changes. The bank variant has an extra byte.
+		if msg[4] == 0x07 {
+			if len(d) == 0 {
+				return nil, false
+			}
+			d = d[1:]
+		}
+		if len(d) < 2 {
+			return nil, false
+		}
+		count := int(d[1])
+		d = d[2:]
+		if len(d) < 4*count {
+			return nil, false
+		}
+		for i := 0; i < count; i++ {
+			t.setFreq(int(d[0]), d[1:4])
+			d = d[4:]
+		}
+
+	case 0x08:
+		// Scale/octave tuning in cents from -64 to 63.
+		if len(d) < 3+12 {
+			return nil, false
+		}
+		var cents [12]float64
+		for i, b := range d[3 : 3+12] {
+			cents[i] = float64(b) - 64
+		}
+		t.setOctave(cents[:])
+
+	case 0x09:
+		// Scale/octave tuning as 14-bit values from -100 to 100 cents.
+		if len(d) < 3+24 {
+			return nil, false
+		}
+		var cents [12]float64
+		d = d[3:]
+		for i := range cents {
+			v := int(d[2*i])<<7 | int(d[2*i+1])
+			cents[i] = float64(v-8192) * 100 / 8192
+		}
+		t.setOctave(cents[:])
+
+	default:
+		return nil, false
+	}
+
+	return t, true
+}
+
+// ----------------------------------------------------------------------------
+// Only called from the jack callback.
+
+// retuneAll: Retune the sounding voices on every key, after the selected
+// tuning changes.
+func (s *Sampler) retuneAll() {
+	for _, ks := range s.keySamplers {
+		if ks != nil {
+			ks.retune()
+		}
+	}
+}
+
+// retune: Apply MTS tuning changes. Real-time changes also retune the voices
+// that are sounding on the keys that changed.
+func (s *Sampler) retune(t *MtsTuning) {
+	s.controls.mts.merge(t)
+	if !t.realTime {
+		return
+	}
+	for _, ks := range s.keySamplers {
+		if ks == nil {
+			continue
+		}
+		// The message tunes the notes as received.
+		note := ks.Key - int(s.controls.Transpose)
+		if note >= 0 && note < 128 && t.set[note] {
+			ks.retune()
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package jlsampler
+
+import (
+	"math"
+	"testing"
+)
+
+// mtsBulk: A bulk dump setting every key to its equal tempered pitch, except
+// for the given three byte frequencies.
+func mtsBulk(bank bool, freqs map[int][3]byte) []byte {
+	msg := []byte{0xf0, 0x7e, 0x7f, 0x08, 0x01}
+	if bank {
+		msg[4] = 0x04
+		msg = append(msg, 0x00)
+	}
+	msg = append(msg, 0x00)
+	msg = append(msg, []byte("test tuning     ")...)
+	for key := 0; key < 128; key++ {
+		f, ok := freqs[key]
+		if !ok {
+			f = [3]byte{byte(key), 0, 0}
+		}
+		msg = append(msg, f[:]...)
+	}
+	return append(msg, 0x00, 0xf7)
+}
+
+func TestParseMts(t *testing.T) {
+	bulk := mtsBulk(false, map[int][3]byte{
+		69: {69, 0x40, 0x00},   // Half a semitone sharp.
+		70: {68, 0x00, 0x00},   // Tuned to G#.
+		71: {0x7f, 0x7f, 0x7f}, // No change.
+	})
+	bankBulk := mtsBulk(true, map[int][3]byte{60: {59, 0x60, 0x00}})
+
+	octave1 := []byte{0xf0, 0x7f, 0x7f, 0x08, 0x08, 0x03, 0x7f, 0x7f}
+	for pc := 0; pc < 12; pc++ {
+		octave1 = append(octave1, 64)
+	}
+	octave1[8+9] = 64 + 14 // A +14 cents.
+	octave1[8+0] = 64 - 64 // C -64 cents.
+	octave1 = append(octave1, 0xf7)
+
+	octave2 := []byte{0xf0, 0x7e, 0x7f, 0x08, 0x09, 0x03, 0x7f, 0x7f}
+	for pc := 0; pc < 12; pc++ {
+		octave2 = append(octave2, 0x40, 0x00)
+	}
+	octave2[8+2*9] = 0x60  // A +50 cents.
+	octave2[8+2*11] = 0x7f // B +100 cents, less one step.
+	octave2[8+2*11+1] = 0x7f
+	octave2 = append(octave2, 0xf7)
+
+	tests := []struct {
+		name     string
+		msg      []byte
+		ok       bool
+		realTime bool
+		offsets  map[int]float64 // Keys that are set.
+		unset    []int
+	}{
+		{
+			name:    "bulk dump",
+			msg:     bulk,
+			ok:      true,
+			offsets: map[int]float64{0: 0, 60: 0, 69: 0.5, 70: -2, 127: 0},
+			unset:   []int{71},
+		},
+		{
+			name: "truncated bulk dump",
+			msg:  append(append([]byte{}, bulk[:len(bulk)-10]...), 0xf7),
+		},
+		{
+			name:    "bulk dump with bank",
+			msg:     bankBulk,
+			ok:      true,
+			offsets: map[int]float64{59: 0, 60: -0.25},
+		},
+		{
+			name: "truncated bulk dump with bank",
+			msg:  []byte{0xf0, 0x7e, 0x7f, 0x08, 0x04, 0xf7},
+		},
+		{
+			name: "single note",
+			msg: []byte{0xf0, 0x7f, 0x7f, 0x08, 0x02, 0x00, 0x02,
+				60, 61, 0x00, 0x00,
+				62, 61, 0x40, 0x00,
+				0xf7},
+			ok:       true,
+			realTime: true,
+			offsets:  map[int]float64{60: 1, 62: -0.5},
+			unset:    []int{61, 69},
+		},
+		{
+			name: "single note with bank",
+			msg: []byte{0xf0, 0x7e, 0x7f, 0x08, 0x07, 0x00, 0x00, 0x01,
+				69, 0x7f, 0x7f, 0x7f,
+				0xf7},
+			ok:    true,
+			unset: []int{69},
+		},
+		{
+			name: "truncated single note",
+			msg: []byte{0xf0, 0x7f, 0x7f, 0x08, 0x02, 0x00, 0x02,
+				60, 61, 0x00, 0x00,
+				62, 61,
+				0xf7},
+		},
+		{
+			name:     "scale/octave, 1 byte",
+			msg:      octave1,
+			ok:       true,
+			realTime: true,
+			offsets: map[int]float64{
+				0: -0.64, 9: 0.14, 60: -0.64, 62: 0, 69: 0.14, 117: 0.14},
+		},
+		{
+			name: "truncated scale/octave, 1 byte",
+			msg:  append(append([]byte{}, octave1[:len(octave1)-2]...), 0xf7),
+		},
+		{
+			name: "scale/octave, 2 bytes",
+			msg:  octave2,
+			ok:   true,
+			offsets: map[int]float64{
+				60: 0, 69: 0.5, 71: 0.99987792968750, 21: 0.5},
+		},
+		{
+			name: "truncated scale/octave, 2 bytes",
+			msg:  append(append([]byte{}, octave2[:len(octave2)-2]...), 0xf7),
+		},
+		{
+			name: "not MTS",
+			msg:  []byte{0xf0, 0x7e, 0x7f, 0x06, 0x01, 0xf7},
+		},
+		{
+			name: "unknown MTS message",
+			msg:  []byte{0xf0, 0x7e, 0x7f, 0x08, 0x03, 0x00, 0x00, 0xf7},
+		},
+		{
+			name: "missing end",
+			msg:  bulk[:len(bulk)-1],
+		},
+		{
+			name: "too short",
+			msg:  []byte{0xf0, 0x7e, 0xf7},
+		},
+	}
+
+	for _, test := range tests {
+		tuning, ok := parseMts(test.msg)
+		if ok != test.ok {
+			t.Errorf("%s: ok %v, want %v.", test.name, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if tuning.realTime != test.realTime {
+			t.Errorf("%s: real-time %v.", test.name, tuning.realTime)
+		}
+		for key, want := range test.offsets {
+			got, set := tuning.Offset(key)
+			if !set || math.Abs(got-want) > 1e-9 {
+				t.Errorf("%s: key %d offset %v (set %v), want %v.",
+					test.name, key, got, set, want)
+			}
+		}
+		for _, key := range test.unset {
+			if _, set := tuning.Offset(key); set {
+				t.Errorf("%s: key %d is set.", test.name, key)
+			}
+		}
+	}
+}
+
+func TestMtsMerge(t *testing.T) {
+	var tuning MtsTuning
+	a, _ := parseMts([]byte{0xf0, 0x7f, 0x7f, 0x08, 0x02, 0x00, 0x01,
+		60, 61, 0x00, 0x00, 0xf7})
+	b, _ := parseMts([]byte{0xf0, 0x7f, 0x7f, 0x08, 0x02, 0x00, 0x01,
+		62, 61, 0x00, 0x00, 0xf7})
+	tuning.merge(a)
+	tuning.merge(b)
+
+	if x, _ := tuning.Offset(60); x != 1 {
+		t.Errorf("Key 60: offset %v, want 1.", x)
+	}
+	if x, _ := tuning.Offset(62); x != -1 {
+		t.Errorf("Key 62: offset %v, want -1.", x)
+	}
+	if !tuning.active() {
+		t.Error("Tuning isn't active.")
+	}
+}
+
+// MTS tunes the notes as received, before Transpose.
+func TestMtsTranspose(t *testing.T) {
+	// Note 62 is tuned up a semitone, in real time.
+	msg := []byte{0xf0, 0x7f, 0x7f, 0x08, 0x02, 0x00, 0x01,
+		62, 63, 0x00, 0x00, 0xf7}
+	want := float32(math.Pow(2, 1.0/12))
+
+	tests := []struct {
+		transpose int8
+		key       int // The key note 62 plays.
+	}{
+		{0, 62},
+		{2, 64},
+		{-2, 60},
+	}
+
+	for _, test := range tests {
+		s := newTestSampler()
+		s.controls.Transpose = test.transpose
+		s.applyEvent(&Event{Type: EventNoteOn, Note: 62, Value: 1})
+
+		tuning, _ := parseMts(msg)
+		s.applyEvent(&Event{Type: EventTuning, Tuning: tuning})
+
+		for _, key := range []int{60, 62, 64} {
+			offset := s.controls.tuningOffset(key)
+			if key == test.key && offset != 1 {
+				t.Errorf("Transpose %d: key %d offset %v, want 1.",
+					test.transpose, key, offset)
+			} else if key != test.key && offset != 0 {
+				t.Errorf("Transpose %d: key %d offset %v, want 0.",
+					test.transpose, key, offset)
+			}
+		}
+
+		ps := s.keySamplers[test.key].playing[0]
+		if math.Abs(float64(ps.rate-want)) > 1e-6 {
+			t.Errorf("Transpose %d: voice rate %v, want %v.",
+				test.transpose, ps.rate, want)
+		}
+	}
+}
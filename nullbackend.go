@@ -0,0 +1,88 @@
+package jlsampler
+
+import (
+	"time"
+)
+
+const (
+	nullBackendPeriod = 256 // Frames per period for timer driven backends.
+)
+
+// ----------------------------------------------------------------------------
+// NullBackend: Drives the processing callback from a timer and discards the
+// output. Useful for running without sound hardware.
+type NullBackend struct {
+	rate   int
+	bufIn  [][]float32
+	bufOut [][]float32
+	done   chan bool
+
+	// Called with the output of each period. Used by FileBackend.
+	write func(bufOut [][]float32) error
+}
+
+func NewNullBackend(rate int) *NullBackend {
+	nb := new(NullBackend)
+	nb.rate = rate
+	return nb
+}
+
+func (nb *NullBackend) Open(name string, nIn, nOut int) error {
+	nb.bufIn = makeBuffers(nIn, nullBackendPeriod)
+	nb.bufOut = makeBuffers(nOut, nullBackendPeriod)
+	return nil
+}
+
+func makeBuffers(nChan, size int) [][]float32 {
+	bufs := make([][]float32, nChan)
+	for i := range bufs {
+		bufs[i] = make([]float32, size)
+	}
+	return bufs
+}
+
+func (nb *NullBackend) SampleRate() int {
+	return nb.rate
+}
+
+func (nb *NullBackend) RegisterCallback(fn ProcessFunc) {
+	nb.done = make(chan bool)
+	go nb.run(fn)
+}
+
+func (nb *NullBackend) run(fn ProcessFunc) {
+	period := time.Duration(nullBackendPeriod) * time.Second /
+		time.Duration(nb.rate)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-nb.done:
+			nb.done <- true
+			return
+		case <-ticker.C:
+		}
+
+		if err := fn(nb.bufIn, nb.bufOut); err != nil {
+			Println("Error in process callback:", err)
+			continue
+		}
+
+		if nb.write != nil {
+			if err := nb.write(nb.bufOut); err != nil {
+				Println("Error writing output:", err)
+			}
+		}
+	}
+}
+
+func (nb *NullBackend) Close() error {
+	if nb.done != nil {
+		// Wait for the callback goroutine to exit.
+		nb.done <- true
+		<-nb.done
+		nb.done = nil
+	}
+	return nil
+}
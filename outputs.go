@@ -0,0 +1,102 @@
+package jlsampler
+
+// ----------------------------------------------------------------------------
+// OutputPair: A stereo output pair, declared in defaults.js as an entry in
+// Controls.Outputs. Notes are routed to the first pair whose key range
+// contains them, or to the first pair if there is none. A pair with Release
+// set takes release samples for the keys in its range instead of notes.
+// Release samples for other keys go with their notes.
+//
+// If KeyLow and KeyHigh are both zero, the pair covers all keys.
+//
+// Each pair has its own reverb, EQ and limiter, with the same settings. A
+// key's resonance goes to the pair its notes are routed to. When writing a
+// single stereo file, with the file backend or the render command, the pairs
+// are mixed before the master effects instead, so that they process and
+// limit the whole mix.
+type OutputPair struct {
+	KeyLow  int  // Lowest key routed to the pair.
+	KeyHigh int  // Highest key routed to the pair.
+	Release bool // Route release samples instead of notes.
+}
+
+func (o OutputPair) contains(key int) bool {
+	if o.KeyLow == 0 && o.KeyHigh == 0 {
+		return true
+	}
+	return key >= o.KeyLow && key <= o.KeyHigh
+}
+
+// numOutputs: The number of stereo output pairs.
+func (c *Controls) numOutputs() int {
+	if len(c.Outputs) == 0 {
+		return 1
+	}
+	return len(c.Outputs)
+}
+
+// outputFor: The output pair for notes, or release samples, of the key.
+func (c *Controls) outputFor(key int, release bool) int {
+	note := 0
+	for i, o := range c.Outputs {
+		if !o.Release && o.contains(key) {
+			note = i
+			break
+		}
+	}
+
+	if release {
+		for i, o := range c.Outputs {
+			if o.Release && o.contains(key) {
+				return i
+			}
+		}
+	}
+
+	return note
+}
+
+// routeOutputs: Set up the output buffers and route each key sampler.
+func (s *Sampler) routeOutputs() {
+	n := s.controls.numOutputs()
+	s.outs = make([]Sound, n)
+	s.segs = make([]Sound, n)
+	s.buf = &s.outs[0]
+
+	for _, ks := range s.keySamplers {
+		if ks != nil {
+			ks.out = s.controls.outputFor(ks.Key, false)
+			ks.releaseOut = s.controls.outputFor(ks.Key, true)
+		}
+	}
+
+	if n > 1 {
+		Println("Output pairs:", n)
+	}
+}
+
+// fxPairs: The number of output pairs processed by the master effects. Only
+// the first has any output after a mixdown.
+func (s *Sampler) fxPairs() int {
+	if s.stereo {
+		return 1
+	}
+	return len(s.outs)
+}
+
+// ----------------------------------------------------------------------------
+// Only called from the jack callback.
+
+// mixDown: Add all output pairs into the first pair, and clear the others.
+// Used when writing a single stereo file, so that the master effects and
+// limiter process the whole mix.
+func (s *Sampler) mixDown() {
+	for i := 1; i < len(s.outs); i++ {
+		out := &s.outs[i]
+		for j := 0; j < out.Len; j++ {
+			s.buf.L[j] += out.L[j]
+			s.buf.R[j] += out.R[j]
+		}
+		out.Zero()
+	}
+}
@@ -0,0 +1,36 @@
+package jlsampler
+
+import (
+	"testing"
+)
+
+// Every output pair gets reverb, not just the first.
+func TestReverbEveryPair(t *testing.T) {
+	s := newTestSampler()
+	s.controls.Outputs = []OutputPair{{KeyLow: 21, KeyHigh: 61}, {}}
+	s.routeOutputs()
+
+	ir := make([]float32, 2*reverbBlock)
+	ir[0] = 1
+	irSample := NewSampleFromArrays(ir, nil, 32)
+	s.reverbs = []*Reverb{
+		NewReverb(irSample, s.outRate), NewReverb(irSample, s.outRate)}
+
+	bufOut := makeBuffers(2*len(s.outs), reverbBlock)
+	for i := range s.outs {
+		s.outs[i] = Sound{reverbBlock, bufOut[2*i], bufOut[2*i+1]}
+	}
+
+	// The wet signal is delayed by one block.
+	s.outs[1].L[0] = 1
+	s.processReverb()
+	s.outs[1].L[0] = 0
+	s.processReverb()
+
+	if wet := s.outs[1].L[0]; wet < float32(s.controls.ReverbWet)/2 {
+		t.Fatalf("Second pair is dry: %v", wet)
+	}
+	if s.outs[0].L[0] != 0 {
+		t.Fatal("Reverb leaked into the first pair.")
+	}
+}
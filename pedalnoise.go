@@ -0,0 +1,141 @@
+package jlsampler
+
+import (
+	"math"
+	"path/filepath"
+	"sort"
+)
+
+// The pedal speed is measured between updates. Times outside this range are
+// clamped, so that a switch pedal that was at rest counts as fast.
+const (
+	pedalMinTime = 0.001 // Seconds.
+	pedalMaxTime = 0.05  // Seconds.
+)
+
+// ----------------------------------------------------------------------------
+// PedalNoise: Sustain pedal noise samples, loaded from files named
+// pedal-down-VV and pedal-up-VV in the samples directory. A noise is played
+// when the dampers leave or meet the strings, at a level that depends on how
+// fast the pedal was moving.
+type PedalNoise struct {
+	down *SampleLayer
+	up   *SampleLayer
+
+	value float64 // Pedal value at the last update.
+	time  float64 // Time of the last update in seconds.
+
+	playing []*PlayingSample
+}
+
+// pedalNoisePaths: Loadable files matching the given glob, sorted.
+func pedalNoisePaths(glob string) []string {
+	matches, _ := filepath.Glob(glob)
+	paths := make([]string, 0, len(matches))
+	for _, path := range matches {
+		if isSampleFile(path) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// loadPedalNoise: Load the pedal noise samples, if any.
+func (s *Sampler) loadPedalNoise() error {
+	pn := new(PedalNoise)
+	pn.down = new(SampleLayer)
+	pn.up = new(SampleLayer)
+	pn.playing = make([]*PlayingSample, 0, 16)
+
+	for _, l := range []struct {
+		glob  string
+		layer *SampleLayer
+	}{
+		{"samples/pedal-down-*", pn.down},
+		{"samples/pedal-up-*", pn.up},
+	} {
+		for _, path := range pedalNoisePaths(l.glob) {
+			sample, err := LoadSampleFile(path, s.controls.Bits16)
+			if err != nil {
+				Println("Failed to load sample:", path, "\nError:", err)
+				return err
+			}
+			l.layer.AddSample(sample)
+		}
+	}
+
+	if pn.down.NumSamples() != 0 || pn.up.NumSamples() != 0 {
+		Println("Loaded pedal noise:",
+			pn.down.NumSamples(), "down,", pn.up.NumSamples(), "up")
+	}
+
+	s.pedal = pn
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Functions below are only called from the jack callback.
+
+// sustainPedal: Called when the sustain pedal moves to x. Plays a noise if
+// the pedal crossed the point where the dampers lift.
+func (s *Sampler) sustainPedal(x float64) {
+	pn := s.pedal
+	if pn == nil {
+		return
+	}
+
+	now := s.now()
+	dt := now - pn.time
+	if dt < pedalMinTime {
+		dt = pedalMinTime
+	} else if dt > pedalMaxTime {
+		dt = pedalMaxTime
+	}
+	speed := math.Abs(x-pn.value) / dt
+
+	thresh := s.controls.HalfPedalLow
+	if pn.value <= thresh && x > thresh {
+		pn.trigger(s.controls, pn.down, speed)
+	} else if pn.value > thresh && x <= thresh {
+		pn.trigger(s.controls, pn.up, speed)
+	}
+
+	pn.value = x
+	pn.time = now
+}
+
+// trigger: Play a sample from the layer at a level set by the pedal speed
+// in pedal units (0 to 1) per second.
+func (pn *PedalNoise) trigger(c *Controls, sl *SampleLayer, speed float64) {
+	if sl.NumSamples() == 0 || c.PedalNoiseAmp <= 0 {
+		return
+	}
+
+	level := 1.0
+	if c.PedalNoiseSpeed > 0 && speed < c.PedalNoiseSpeed {
+		level = speed / c.PedalNoiseSpeed
+	}
+
+	_, sample := sl.GetSample(-1)
+	amp := float32(c.PedalNoiseAmp * level)
+	ps := NewPlayingSample(c, sample, nil, amp, 0, 0, 0)
+	ps.release = true
+	ps.serial = c.sampler.nextSerial()
+	pn.playing = append(pn.playing, ps)
+}
+
+func (pn *PedalNoise) WriteOutput(buf *Sound, amp, di []float32) {
+	iIn := 0
+	for _, ps := range pn.playing {
+		if ps.WriteOutput(buf, amp, di) {
+			pn.playing[iIn] = ps
+			iIn++
+		}
+	}
+	pn.playing = pn.playing[:iIn]
+}
+
+func (pn *PedalNoise) HasData() bool {
+	return len(pn.playing) != 0
+}
@@ -1,5 +1,9 @@
 package jlsampler
 
+import (
+	"math"
+)
+
 // ----------------------------------------------------------------------------
 type PlayingSample struct {
 	controls *Controls // Controls!
@@ -7,14 +11,32 @@ type PlayingSample struct {
 	sample1 *Sample // Quieter sample being played.
 	sample2 *Sample // Louder sample being played if mixLayers is true.
 	mix     float32 // The mix parameter: (1-mix)*sample1 + mix*sample2.
-	idx     float32 // Index being played in the given sample.
-	idxMax  float32 // Total length of sample.
+	idx     float64 // Index being played in the given sample.
+	idxMax  float64 // Total length of sample.
 	amp1    float32 // Current amplification for sample 1.
 	amp2    float32 // Current amplification for sample 2.
 	pan     float32 // Pan of playing sample: -1 is hard left, 1 is hard right.
+	width1  float32 // Stereo width for sample 1.
+	width2  float32 // Stereo width for sample 2.
 	tau     float32 // Decay constant (0 is disabled).
 
 	fadeAmp float32 // Fade in amplification if controls.TauFadeIn > 0.
+
+	env    *Envelope    // Amplitude envelope, or nil to use tau.
+	envAmp float32      // Current envelope level.
+	filter *VoiceFilter // Filter, or nil.
+
+	kernel InterpKernel // Interpolation kernel for the current buffer.
+
+	serial uint64 // Order in which voices were started. See Sampler.stealVoices.
+	stolen bool   // True if the voice has been stolen and is fading out.
+
+	release bool // True for release samples, which aren't damped.
+	damped  bool // True if tau was set by a half pedal.
+
+	loop *SampleLoop // Sustain loop, or nil if not looping.
+	dir  float32     // Playback direction, -1 when reversed in a ping-pong loop.
+	rate float32     // Playback rate multiplier for the tuning.
 }
 
 // NewPlayingSample:
@@ -29,11 +51,26 @@ func NewPlayingSample(
 	ps.sample1 = sample1
 	ps.sample2 = sample2
 	ps.mix = mix
-	ps.idxMax = float32(sample1.Len - 1)
+	ps.idxMax = float64(sample1.Len - 1)
 	ps.amp1 = amp1
 	ps.amp2 = amp2
 	ps.pan = pan
 	ps.tau = 0
+	ps.dir = 1
+	ps.rate = 1
+	ps.envAmp = 1
+
+	// Mixed layers can only loop if the loops match.
+	if sample2 == nil || (sample1.Loop != nil && sample2.Loop != nil &&
+		*sample1.Loop == *sample2.Loop) {
+		ps.loop = sample1.Loop
+	}
+
+	// Stereo width. Mono samples are placed by the pan alone.
+	ps.width1 = float32(controls.Width) * sample1.Width
+	if sample2 != nil {
+		ps.width2 = float32(controls.Width) * sample2.Width
+	}
 
 	if controls.TauFadeIn != 0 {
 		ps.fadeAmp = 1
@@ -42,12 +79,12 @@ func NewPlayingSample(
 	}
 
 	if sample2 != nil {
-		ps.idx = float32(sample2.Idx0) - controls.NFadeIn
-		if float32(sample2.Len-1) > ps.idxMax {
-			ps.idxMax = float32(sample2.Len - 1)
+		ps.idx = float64(sample2.Idx0) - float64(controls.NFadeIn)
+		if float64(sample2.Len-1) > ps.idxMax {
+			ps.idxMax = float64(sample2.Len - 1)
 		}
 	} else {
-		ps.idx = float32(sample1.Idx0) - controls.NFadeIn
+		ps.idx = float64(sample1.Idx0) - float64(controls.NFadeIn)
 	}
 
 	if ps.idx < 0 {
@@ -57,21 +94,114 @@ func NewPlayingSample(
 	return ps
 }
 
+// Level: The current amplitude of the voice.
+func (ps *PlayingSample) Level() float32 {
+	return (ps.amp1*(1-ps.mix) + ps.amp2*ps.mix) * ps.envAmp
+}
+
+// Release: Start the release of a voice that isn't sustained. With an
+// envelope this starts the release stage. Otherwise the voice decays with
+// Controls.Tau.
+func (ps *PlayingSample) Release() {
+	ps.releaseLoop()
+	if ps.release {
+		return
+	}
+	if ps.filter != nil {
+		ps.filter.Release()
+	}
+	if ps.env != nil {
+		// The envelope's release takes over from a half pedal.
+		ps.env.Release()
+		if ps.damped {
+			ps.tau = 0
+		}
+	} else if ps.tau == 0 || ps.damped {
+		ps.tau = float32(ps.controls.Tau)
+	}
+	ps.damped = false
+}
+
+// halfDamp: Decay with the dampers lifted by the given fraction. The time
+// constant is the voice's own release time constant, from its envelope or
+// Tau, divided by 1-lift. Voices that are already decaying for another
+// reason, including an envelope release, aren't affected.
+func (ps *PlayingSample) halfDamp(lift float64) {
+	if ps.release || ps.stolen || (ps.tau != 0 && !ps.damped) {
+		return
+	}
+
+	base := ps.controls.Tau
+	if ps.env != nil {
+		if ps.env.stage >= envRelease {
+			return
+		}
+		base = ps.env.releaseFactor()
+	}
+
+	var tau float32
+	if lift < 1 && base != 0 {
+		tau = float32(math.Pow(base, 1-lift))
+	}
+	ps.tau = tau
+	ps.damped = tau != 0
+}
+
+// Steal: Fade the voice out quickly with the given decay factor. If tau is
+// zero the voice is cut off.
+func (ps *PlayingSample) Steal(tau float32) {
+	ps.stolen = true
+	if tau == 0 {
+		tau = ampCutoff
+	}
+	if ps.tau == 0 || tau < ps.tau {
+		ps.tau = tau
+	}
+}
+
+// interp: Return the interpolated sample at the current index, crossfading
+// at the loop end.
+func (ps *PlayingSample) interp(s *Sample) (float32, float32) {
+	if ps.loop == nil {
+		return s.InterpKernel(ps.idx, ps.kernel)
+	}
+
+	pingPong := ps.loopMode() == LoopPingPong
+	L, R := s.InterpLoop(ps.idx, ps.kernel, ps.loop, pingPong)
+	if xf := ps.loopXfade(); xf > 0 {
+		idx := ps.idx - float64(ps.loop.End-ps.loop.Start)
+		L2, R2 := s.InterpKernel(idx, ps.kernel)
+		L += xf * (L2 - L)
+		R += xf * (R2 - R)
+	}
+	return L, R
+}
+
 // Add the current sample value to the buffer. Applying fades and panning.
 func (ps *PlayingSample) addCurrentSample(buf *Sound, amp float32, i int) {
-	L, R := ps.sample1.Interp(ps.idx)
+	L, R := ps.interp(ps.sample1)
+	if ps.width1 != 1 && !ps.sample1.Mono {
+		L, R = applyWidth(L, R, ps.width1)
+	}
 	L *= ps.amp1
 	R *= ps.amp1
 
 	if ps.mix != 0 {
-		L2, R2 := ps.sample2.Interp(ps.idx)
+		L2, R2 := ps.interp(ps.sample2)
+		if ps.width2 != 1 && !ps.sample2.Mono {
+			L2, R2 = applyWidth(L2, R2, ps.width2)
+		}
 		L = L*(1-ps.mix) + L2*ps.amp2*ps.mix
 		R = R*(1-ps.mix) + R2*ps.amp2*ps.mix
 	}
 
-	// Fade in.
-	L *= (1 - ps.fadeAmp)
-	R *= (1 - ps.fadeAmp)
+	if ps.filter != nil {
+		L, R = ps.filter.Process(L, R)
+	}
+
+	// Fade in and envelope.
+	L *= (1 - ps.fadeAmp) * ps.envAmp
+	R *= (1 - ps.fadeAmp) * ps.envAmp
 
 	// Pan.
 	if ps.pan < 0 {
@@ -87,6 +217,8 @@ func (ps *PlayingSample) addCurrentSample(buf *Sound, amp float32, i int) {
 }
 
 func (ps *PlayingSample) WriteOutput(buf *Sound, amp, di []float32) bool {
+	ps.kernel = InterpKernel(ps.controls.Interpolation)
+
 	for i, _ := range buf.L {
 		// Update decay amplitude.
 		if ps.tau != 0 {
@@ -98,6 +230,14 @@ func (ps *PlayingSample) WriteOutput(buf *Sound, amp, di []float32) bool {
 			}
 		}
 
+		// Update envelope.
+		if ps.env != nil {
+			ps.envAmp = ps.env.Next()
+			if ps.env.Done() {
+				return false
+			}
+		}
+
 		// Update fade in.
 		if ps.fadeAmp != 0 {
 			ps.fadeAmp *= float32(ps.controls.TauFadeIn)
@@ -106,14 +246,19 @@ func (ps *PlayingSample) WriteOutput(buf *Sound, amp, di []float32) bool {
 			}
 		}
 
+		// Done playing? Looping voices stay inside the loop, which may
+		// end at the last sample. This is checked before playing, since the
+		// loop may have been dropped on release.
+		if ps.loop == nil && ps.idx >= ps.idxMax {
+			return false
+		}
+
 		ps.addCurrentSample(buf, amp[i], i)
 
 		// Update index.
-		ps.idx += di[i]
-
-		// Done playing?
-		if ps.idx >= ps.idxMax {
-			return false
+		ps.idx += float64(di[i] * ps.rate * ps.dir)
+		if ps.loop != nil {
+			ps.stepLoop()
 		}
 	}
 
@@ -0,0 +1,49 @@
+package jlsampler
+
+import (
+	"io"
+	"os"
+)
+
+// ----------------------------------------------------------------------------
+// RawMidiSource: Read a raw midi byte stream from a file, such as an alsa
+// rawmidi device node (/dev/snd/midiC1D0) or a fifo.
+type RawMidiSource struct {
+	path string
+}
+
+func NewRawMidiSource(path string) *RawMidiSource {
+	rs := new(RawMidiSource)
+	rs.path = path
+	return rs
+}
+
+func (rs *RawMidiSource) Run(fn func(MidiEvent)) error {
+	f, err := os.Open(rs.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return parseMidiStream(f, fn)
+}
+
+// parseMidiStream: Parse raw midi bytes from r until EOF.
+func parseMidiStream(r io.Reader, fn func(MidiEvent)) error {
+	var parser MidiParser
+	buf := make([]byte, 256)
+
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			if ev, ok := parser.Parse(b); ok {
+				fn(ev)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
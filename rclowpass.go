@@ -9,10 +9,10 @@ func freq3db(freq float64, order int) float64 {
 	return freq / math.Sqrt(math.Pow(2, 1.0/float64(order)) - 1)
 }
 
-func rcLowPass1(x []int16, freq float64) {
+func rcLowPass1(x []float32, freq float64, rate int) {
 	y := make([]float64, len(x))
 
-	dt := float64(1.0 / sampleRate)
+	dt := 1.0 / float64(rate)
 	rc := 1.0 / (2.0 * math.Pi * freq)
 	alpha := dt / (rc + dt)
 	
@@ -31,14 +31,15 @@ func rcLowPass1(x []int16, freq float64) {
 	}
 	
 	for i, _ := range x {
-		x[i] = int16(maxVal16 * y[i] / ymax)
+		x[i] = float32(y[i] / ymax)
 	}
 }
 
-func rcLowPass(x []int16, freq float64, order int) {
+// rcLowPass: Filter x, sampled at the given rate, in place.
+func rcLowPass(x []float32, freq float64, order, rate int) {
 	freq = freq3db(freq, order)
 	for i := 0; i < order; i++ {
-		rcLowPass1(x, freq)
+		rcLowPass1(x, freq, rate)
 	}
 }
 
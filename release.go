@@ -0,0 +1,84 @@
+package jlsampler
+
+import (
+	"math"
+)
+
+// ----------------------------------------------------------------------------
+// Release samples are loaded from files named off-NNN-LL-VV, like the note
+// samples. They're played when a key is released, or when the sustain pedal
+// lifts for keys that were released while it was down.
+//
+// The release layer is chosen by the note on velocity. The level is
+// Controls.ReleaseAmp scaled by the note on velocity (see GammaAmp) and
+// falls off with the time the note was held (see ReleaseHoldTime). The
+// samples are played at their recorded level, without RMS normalization.
+
+func (ks *KeySampler) AddReleaseSample(sample *Sample, layer int) {
+	for len(ks.release) < layer+1 {
+		ks.release = append(ks.release, new(SampleLayer))
+	}
+	ks.release[layer].AddSample(sample)
+}
+
+// releaseAmp: The amplitude for a release sample after the key was held
+// for the given time in seconds.
+func (ks *KeySampler) releaseAmp(held float64) float32 {
+	c := ks.controls
+	amp := c.ReleaseAmp * math.Pow(ks.velocity, c.GammaAmp)
+	if c.ReleaseHoldTime > 0 {
+		amp *= math.Exp(-held / c.ReleaseHoldTime)
+	}
+	return float32(amp)
+}
+
+// triggerRelease: Start a release sample for a key released at time now.
+func (ks *KeySampler) triggerRelease(now float64) {
+	ks.releasePending = false
+
+	numLayers := len(ks.release)
+	if numLayers == 0 || ks.controls.ReleaseAmp <= 0 {
+		return
+	}
+
+	layer := int(
+		float64(numLayers) * math.Pow(ks.velocity, ks.controls.GammaLayer))
+	if layer > numLayers-1 {
+		layer = numLayers - 1
+	}
+
+	sl := ks.release[layer]
+	if sl.NumSamples() == 0 {
+		return
+	}
+	_, sample := sl.GetSample(-1)
+
+	amp := ks.releaseAmp(now - ks.onTime)
+	pan := ks.controls.CalcPan(ks.Key)
+
+	ps := NewPlayingSample(ks.controls, sample, nil, amp, 0, pan, 0)
+	ps.release = true
+	ps.rate = ks.playbackRate()
+	ps.serial = ks.controls.sampler.nextSerial()
+	ks.playing = append(ks.playing, ps)
+}
+
+// ----------------------------------------------------------------------------
+// Only called from the jack callback.
+
+// checkSustainRelease: Trigger release samples for keys released while the
+// sustain or sostenuto pedal was down, once the pedal lifts.
+func (s *Sampler) checkSustainRelease() {
+	sustain := s.controls.Sustain
+	sostenuto := s.controls.Sostenuto
+	if (s.sustain && !sustain) || (s.sostenuto && !sostenuto) {
+		now := s.now()
+		for _, ks := range s.keySamplers {
+			if ks != nil && ks.releasePending && !ks.sustained() {
+				ks.triggerRelease(now)
+			}
+		}
+	}
+	s.sustain = sustain
+	s.sostenuto = sostenuto
+}
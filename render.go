@@ -0,0 +1,158 @@
+package jlsampler
+
+import (
+	"flag"
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+const (
+	renderMaxTail = 60.0 // Maximum time to render after the last event.
+)
+
+// ----------------------------------------------------------------------------
+// RunRender: Render a midi file to a wav file without jack or alsa. The
+// output is always a 32-bit float wav file. There's no flac encoder in the
+// tree, so flac output isn't supported.
+// Usage: jlsampler render [-rate N] [-block N] sampler-path in.mid out.wav
+func RunRender(args []string) {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	rate := flags.Int("rate", sampleRate, "Output sample rate.")
+	block := flags.Int("block", 256, "Block size in frames.")
+
+	if err := flags.Parse(args); err != nil {
+		return
+	}
+
+	if flags.NArg() != 3 {
+		Println("Usage: render [-rate N] [-block N]",
+			"sampler-path", "in.mid", "out.wav")
+		return
+	}
+
+	if *rate <= 0 || *block <= 0 {
+		Println("Error: rate and block size must be positive.")
+		return
+	}
+
+	events, err := LoadMidiFile(flags.Arg(1))
+	if err != nil {
+		Println("Error:", err)
+		return
+	}
+
+	s, err := loadSampler(flags.Arg(0))
+	if err != nil {
+		Println("Error:", err)
+		return
+	}
+	s.setSampleRate(float64(*rate))
+
+	w, err := NewWavWriter(flags.Arg(2), *rate)
+	if err != nil {
+		Println("Error:", err)
+		return
+	}
+
+	if err = s.Render(events, w, *block); err != nil {
+		Println("Error:", err)
+	}
+
+	if n := s.StolenVoices(); n != 0 {
+		Println("Stolen voices:", n)
+	}
+
+	if err = w.Close(); err != nil {
+		Println("Error:", err)
+	}
+}
+
+// Render: Render the events to w in blocks of the given size. The midi file
+// events are placed directly at their exact frames rather than going through
+// the event queue, so none are dropped however dense the file is. Events
+// queued by other goroutines are still read by readEvents, with the clock
+// driven by the rendered frames. Rendering continues after the last event
+// until all samples have finished playing.
+func (s *Sampler) Render(events []MidiFileEvent, w *WavWriter, block int) error {
+	// All output pairs are mixed into the first.
+	s.stereo = true
+	bufOut := makeBuffers(2*len(s.outs), block)
+	L, R := bufOut[0], bufOut[1]
+
+	var tEnd float64
+	if len(events) > 0 {
+		tEnd = events[len(events)-1].Time
+	}
+	maxFrames := int64((tEnd + renderMaxTail) * s.outRate)
+
+	s.rendering = true
+	defer func() { s.rendering = false }()
+
+	next := 0
+	for frame := int64(0); frame < maxFrames; frame += int64(block) {
+		// Queued events are placed relative to the previous call, which
+		// was at the start of this block.
+		end := frame + int64(block)
+		s.setRenderTime(float64(end) / s.outRate)
+		s.readEvents(block)
+
+		// Add the file events in this block at their frames.
+		for ; next < len(events); next++ {
+			ev := &events[next]
+			at := int64(ev.Time * s.outRate)
+			if at >= end {
+				break
+			}
+			if sev, ok := samplerEvent(ev.MidiEvent); ok {
+				if at < frame {
+					at = frame
+				}
+				sev.Frame = int(at - frame)
+				s.events = append(s.events, sev)
+			}
+		}
+		sort.SliceStable(s.events, func(i, j int) bool {
+			return s.events[i].Frame < s.events[j].Frame
+		})
+
+		s.process(bufOut)
+
+		if err := w.Write(L, R); err != nil {
+			return err
+		}
+
+		if next == len(events) && !s.hasData() {
+			break
+		}
+	}
+
+	return nil
+}
+
+// setRenderTime: Set the clock to t seconds.
+func (s *Sampler) setRenderTime(t float64) {
+	atomic.StoreInt64(&s.renderTime, int64(math.Round(t*1e9)))
+}
+
+func (s *Sampler) hasData() bool {
+	for _, ks := range s.keySamplers {
+		if ks != nil && ks.HasData() {
+			return true
+		}
+	}
+	for _, r := range s.reverbs {
+		if r.Tail() {
+			return true
+		}
+	}
+	if s.resonance != nil && s.resonance.ringing() {
+		return true
+	}
+	for _, l := range s.limiters {
+		if l.Tail() {
+			return true
+		}
+	}
+	return s.pedal != nil && s.pedal.HasData()
+}
@@ -0,0 +1,155 @@
+package jlsampler
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// ReplaySource: Replay time-stamped midi events in real time, from a
+// standard midi file or a text file.
+//
+// The text format has one event per line:
+//
+//	<seconds> on <note> <velocity>
+//	<seconds> off <note> [velocity]
+//	<seconds> cc <control> <value>
+//	<seconds> bend <value>       (-8192 to 8191)
+//	<seconds> at <value>         (channel aftertouch)
+//	<seconds> pat <note> <value> (poly aftertouch)
+//	<seconds> pc <program>
+//
+// Values other than bend are 0 to 127. Blank lines and lines beginning with
+// # are ignored. A path of "-" reads text from stdin.
+type ReplaySource struct {
+	path string
+}
+
+func NewReplaySource(path string) *ReplaySource {
+	rs := new(ReplaySource)
+	rs.path = path
+	return rs
+}
+
+// UsesStdin: True if the source reads from stdin.
+func (rs *ReplaySource) UsesStdin() bool {
+	return rs.path == "-"
+}
+
+func (rs *ReplaySource) Run(fn func(MidiEvent)) error {
+	start := time.Now()
+
+	play := func(ev MidiFileEvent) {
+		wait := time.Duration(ev.Time*float64(time.Second)) - time.Since(start)
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		fn(ev.MidiEvent)
+	}
+
+	if strings.HasSuffix(rs.path, ".mid") || strings.HasSuffix(rs.path, ".midi") {
+		events, err := LoadMidiFile(rs.path)
+		if err != nil {
+			return err
+		}
+		for _, ev := range events {
+			play(ev)
+		}
+		return nil
+	}
+
+	var r io.Reader = os.Stdin
+	if !rs.UsesStdin() {
+		f, err := os.Open(rs.path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	// Text events are played as they're read so stdin can be streamed.
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		ev, err := parseReplayLine(line)
+		if err != nil {
+			return err
+		}
+		play(ev)
+	}
+
+	return scanner.Err()
+}
+
+func parseReplayLine(line string) (MidiFileEvent, error) {
+	var ev MidiFileEvent
+	errLine := errors.New("Malformed replay line: " + line)
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return ev, errLine
+	}
+
+	t, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return ev, errLine
+	}
+	ev.Time = t
+
+	args := make([]int, len(fields)-2)
+	for i, f := range fields[2:] {
+		if args[i], err = strconv.Atoi(f); err != nil {
+			return ev, errLine
+		}
+	}
+
+	nArgs := map[string]int{
+		"on": 2, "off": 1, "cc": 2, "bend": 1, "at": 1, "pat": 2, "pc": 1}
+
+	n, ok := nArgs[fields[1]]
+	if !ok || len(args) < n {
+		return ev, errLine
+	}
+
+	switch fields[1] {
+	case "on":
+		ev.Type = MidiNoteOn
+		ev.Note = int8(args[0])
+		ev.Value = float64(args[1]) / 127.0
+	case "off":
+		ev.Type = MidiNoteOff
+		ev.Note = int8(args[0])
+		if len(args) > 1 {
+			ev.Value = float64(args[1]) / 127.0
+		}
+	case "cc":
+		ev.Type = MidiController
+		ev.Control = int32(args[0])
+		ev.Value = float64(args[1]) / 127.0
+	case "bend":
+		ev.Type = MidiPitchBend
+		ev.Value = pitchBendValue(int32(args[0]))
+	case "at":
+		ev.Type = MidiAftertouch
+		ev.Value = float64(args[0]) / 127.0
+	case "pat":
+		ev.Type = MidiPolyAftertouch
+		ev.Note = int8(args[0])
+		ev.Value = float64(args[1]) / 127.0
+	case "pc":
+		ev.Type = MidiProgramChange
+		ev.Control = int32(args[0])
+	}
+
+	return ev, nil
+}
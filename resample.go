@@ -0,0 +1,123 @@
+package jlsampler
+
+import (
+	"math"
+)
+
+const (
+	resampleZeros     = 16   // Sinc zero crossings on each side of center.
+	resampleBeta      = 8.0  // Kaiser window shape parameter.
+	resampleRolloff   = 0.96 // Cutoff as a fraction of the lower nyquist.
+	resampleMaxPhases = 4096 // Phases are quantized above this.
+)
+
+// ----------------------------------------------------------------------------
+// Resampler: A polyphase windowed-sinc resampler for a fixed rate ratio.
+type Resampler struct {
+	up     int64       // Interpolation factor.
+	down   int64       // Decimation factor.
+	phases int64       // Number of filter phases in the table.
+	half   int         // Half the number of taps.
+	table  [][]float32 // Filter taps for each phase.
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// besselI0: Zeroth order modified bessel function of the first kind.
+func besselI0(x float64) float64 {
+	sum := 1.0
+	term := 1.0
+	for k := 1; k < 50; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+		if term < 1e-12*sum {
+			break
+		}
+	}
+	return sum
+}
+
+func NewResampler(inRate, outRate int) *Resampler {
+	r := new(Resampler)
+	g := gcd(inRate, outRate)
+	r.up = int64(outRate / g)
+	r.down = int64(inRate / g)
+
+	r.phases = r.up
+	if r.phases > resampleMaxPhases {
+		r.phases = resampleMaxPhases
+	}
+
+	// Cutoff relative to the input nyquist frequency.
+	fc := resampleRolloff
+	if r.up < r.down {
+		fc *= float64(r.up) / float64(r.down)
+	}
+
+	r.half = int(math.Ceil(resampleZeros / fc))
+	i0Beta := besselI0(resampleBeta)
+
+	r.table = make([][]float32, r.phases)
+	for p := range r.table {
+		frac := float64(p) / float64(r.phases)
+		taps := make([]float32, 2*r.half)
+		sum := 0.0
+
+		for j := range taps {
+			// Distance from the output position to the input sample.
+			u := frac + float64(r.half-1-j)
+			x := fc * u
+			sinc := 1.0
+			if x != 0 {
+				sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+			}
+			w := u / float64(r.half)
+			win := 0.0
+			if w*w < 1 {
+				win = besselI0(resampleBeta*math.Sqrt(1-w*w)) / i0Beta
+			}
+			h := fc * sinc * win
+			taps[j] = float32(h)
+			sum += h
+		}
+
+		// Normalize for unity gain at DC.
+		for j := range taps {
+			taps[j] = float32(float64(taps[j]) / sum)
+		}
+
+		r.table[p] = taps
+	}
+
+	return r
+}
+
+// Resample: Resample x, returning a new slice.
+func (r *Resampler) Resample(x []float32) []float32 {
+	n := int64(len(x)) * r.up / r.down
+	y := make([]float32, n)
+
+	for i := range y {
+		pos := int64(i) * r.down
+		idx := int(pos / r.up)
+		taps := r.table[(pos%r.up)*r.phases/r.up]
+
+		// Input samples idx-half+1 through idx+half.
+		k0 := idx - r.half + 1
+		var sum float32
+		for j, h := range taps {
+			k := k0 + j
+			if k >= 0 && k < len(x) {
+				sum += h * x[k]
+			}
+		}
+		y[i] = sum
+	}
+
+	return y
+}
@@ -0,0 +1,201 @@
+package jlsampler
+
+import (
+	"math"
+)
+
+const (
+	resonancePartials = 3     // Resonators per key, at the first partials.
+	resonanceDamp     = 0.005 // Time constant for damping a resonator.
+	resonanceLowKey   = 21
+	resonanceHighKey  = 108
+)
+
+// ----------------------------------------------------------------------------
+// resonator: A two-pole resonator for one string partial.
+type resonator struct {
+	w0     float64 // Equal tempered frequency in radians per sample.
+	w      float64 // Tuned frequency in radians per sample.
+	a1, a2 float32 // Feedback coefficients.
+	g      float32 // Input gain, normalizing the peak to 1.
+	y1, y2 float32 // State.
+	active bool    // True while the input or ringing is above ampCutoff.
+}
+
+// ----------------------------------------------------------------------------
+// Resonance: Sympathetic string resonance. The mix excites a resonator bank
+// for each key that is undamped but not playing. Undamped keys are those
+// held down or held by the sustain or sostenuto pedals. Damped keys stop
+// ringing quickly. Each key's resonators follow the key's tuning.
+type Resonance struct {
+	rate    float64
+	time    float64 // Ring time constant the coefficients were computed for.
+	radius  float64 // Pole radius for the ring time.
+	damp    float32 // Per-sample damping factor.
+	keys    [][]resonator
+	offsets [128]float64 // Tuning offset each key's resonators are tuned to.
+	input   []float32
+}
+
+func NewResonance(rate float64) *Resonance {
+	r := new(Resonance)
+	r.rate = rate
+	r.damp = float32(math.Exp(-1 / (resonanceDamp * rate)))
+	r.keys = make([][]resonator, 128)
+
+	for key := resonanceLowKey; key <= resonanceHighKey; key++ {
+		f0 := 440 * math.Pow(2, float64(key-69)/12)
+		for p := 1; p <= resonancePartials; p++ {
+			w := 2 * math.Pi * f0 * float64(p) / rate
+			if w >= 2*math.Pi*filterMaxCutoff {
+				break
+			}
+			r.keys[key] = append(r.keys[key], resonator{w0: w, w: w})
+		}
+	}
+
+	return r
+}
+
+// setTime: Compute coefficients for the given ring time in seconds.
+func (r *Resonance) setTime(t float64) {
+	r.time = t
+	r.radius = math.Exp(-1 / (t * r.rate))
+	for _, bank := range r.keys {
+		for i := range bank {
+			bank[i].setCoefs(r.radius)
+		}
+	}
+}
+
+// tune: Tune the key's resonators to the given offset in semitones.
+func (r *Resonance) tune(key int, offset float64) {
+	r.offsets[key] = offset
+	mult := math.Pow(2, offset/12)
+	bank := r.keys[key]
+	for i := range bank {
+		rs := &bank[i]
+		rs.w = math.Min(rs.w0*mult, 2*math.Pi*filterMaxCutoff)
+		rs.setCoefs(r.radius)
+	}
+}
+
+func (rs *resonator) setCoefs(radius float64) {
+	rs.a1 = float32(2 * radius * math.Cos(rs.w))
+	rs.a2 = float32(-radius * radius)
+	rs.g = float32((1 - radius) *
+		math.Sqrt(1-2*radius*math.Cos(2*rs.w)+radius*radius))
+}
+
+// ----------------------------------------------------------------------------
+// Only called from the jack callback.
+
+// processResonance: Add the resonance of the mix of all output pairs to the
+// pair each key's notes are routed to.
+func (s *Sampler) processResonance() {
+	c := s.controls
+	r := s.resonance
+	if r == nil || (c.Resonance <= 0 && !r.ringing()) {
+		return
+	}
+
+	if c.ResonanceTime != r.time && c.ResonanceTime > 0 {
+		r.setTime(c.ResonanceTime)
+	}
+
+	n := s.buf.Len
+	if len(r.input) != n {
+		r.input = make([]float32, n)
+	}
+	for i := 0; i < n; i++ {
+		r.input[i] = 0
+	}
+	for p := 0; p < s.fxPairs(); p++ {
+		out := &s.outs[p]
+		for i := 0; i < n; i++ {
+			r.input[i] += (out.L[i] + out.R[i]) / 2
+		}
+	}
+
+	amount := float32(c.Resonance)
+
+	for key, bank := range r.keys {
+		if len(bank) == 0 {
+			continue
+		}
+
+		if offset := c.tuningOffset(key); offset != r.offsets[key] {
+			r.tune(key, offset)
+		}
+
+		ks := s.keySamplers[key]
+		undamped := ks != nil && !ks.HasData() &&
+			(c.Sustain || ks.on || ks.sostenuto)
+
+		out := s.buf
+		if ks != nil && ks.out < s.fxPairs() {
+			out = &s.outs[ks.out]
+		}
+
+		gain := amount * c.CalcResonance(key)
+		for j := range bank {
+			rs := &bank[j]
+			if undamped && gain > 0 {
+				rs.process(r.input, out, rs.g*gain, 1)
+			} else if rs.active {
+				rs.process(nil, out, 0, r.damp)
+			}
+		}
+	}
+}
+
+// process: Run the resonator over the input, adding its output to buf. If
+// input is nil, the resonator rings down with the extra damping factor. The
+// resonator is deactivated once its energy and input fall below the cutoff,
+// whether or not it's damped, so a held pedal doesn't keep it ringing forever.
+func (rs *resonator) process(input []float32, buf *Sound, g, damp float32) {
+	y1, y2 := rs.y1, rs.y2
+	var inMax float32
+	for i := range buf.L {
+		var x float32
+		if input != nil {
+			x = g * input[i]
+			if input[i] > inMax {
+				inMax = input[i]
+			} else if -input[i] > inMax {
+				inMax = -input[i]
+			}
+		}
+		y := x + rs.a1*y1 + rs.a2*y2
+		y *= damp
+		y2, y1 = y1, y
+		buf.L[i] += y
+		buf.R[i] += y
+	}
+	rs.y1, rs.y2 = y1, y2
+
+	rs.active = inMax >= ampCutoff || rs.amplitude() >= ampCutoff
+	if !rs.active {
+		rs.y1, rs.y2 = 0, 0
+	}
+}
+
+// amplitude: The amplitude of the ringing resonator. Unlike the state, it
+// doesn't depend on the phase, so it's small only when the ringing is.
+func (rs *resonator) amplitude() float64 {
+	y1, y2 := float64(rs.y1), float64(rs.y2)
+	sin := math.Sin(rs.w)
+	return math.Sqrt(math.Abs(y1*y1+y2*y2-float64(rs.a1)*y1*y2)) / sin
+}
+
+// ringing: True if any resonator is active.
+func (r *Resonance) ringing() bool {
+	for _, bank := range r.keys {
+		for j := range bank {
+			if bank[j].active {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package jlsampler
+
+import (
+	"testing"
+)
+
+// Resonators stop ringing on their own while the sustain pedal is held.
+func TestResonanceStopsUndamped(t *testing.T) {
+	s := newTestSampler()
+	s.resonance = NewResonance(s.outRate)
+	s.controls.Resonance = 1
+	s.controls.Sustain = true
+
+	s.routeOutputs()
+	s.outs[0] = *NewSound(256)
+	s.buf.L[0], s.buf.R[0] = 1, 1
+	s.processResonance()
+	if !s.resonance.ringing() {
+		t.Fatal("The resonators weren't excited.")
+	}
+
+	maxBlocks := int(renderMaxTail * s.outRate / 256)
+	for i := 0; i < maxBlocks && s.resonance.ringing(); i++ {
+		for j := range s.buf.L {
+			s.buf.L[j], s.buf.R[j] = 0, 0
+		}
+		s.processResonance()
+	}
+	if s.resonance.ringing() {
+		t.Fatal("The resonators were still ringing at the maximum tail.")
+	}
+}
@@ -0,0 +1,428 @@
+package jlsampler
+
+const (
+	reverbBlock       = 256  // Head partition size in samples.
+	reverbTailBlock   = 4096 // Tail partition size in samples.
+	reverbMaxTime     = 5.0  // Impulse responses are truncated to this length.
+	reverbMaxPredelay = 0.5  // Maximum predelay in seconds.
+
+	// Head blocks per tail block. The tail's work for each block is spread
+	// over this many head blocks.
+	reverbTailSteps = reverbTailBlock / reverbBlock
+
+	// The head covers the start of the impulse response, up to where the
+	// tail's output is ready.
+	reverbHeadLen = 2*reverbTailBlock - reverbBlock
+)
+
+// ----------------------------------------------------------------------------
+// Reverb: A partitioned FFT convolution reverb on the master bus. Each
+// output pair has its own. The start
+// of the impulse response is cut into short partitions of reverbBlock
+// samples, and each block of input is convolved with every head partition in
+// the frequency domain (overlap-save). The rest of the impulse response is
+// cut into long partitions of reverbTailBlock samples, which are convolved
+// the same way. The tail's output isn't needed until a tail block after its
+// input is complete, so its work is spread evenly over the head blocks in
+// between, and the work in each callback stays small and even however long
+// the impulse response is.
+//
+// The left and right channels are convolved with the left and right
+// channels of the impulse response. Both channels share one FFT by packing
+// them into the real and imaginary parts.
+//
+// The convolution delays the wet signal by reverbBlock samples. This counts
+// toward the predelay, so predelays shorter than one block are rounded up.
+type Reverb struct {
+	rate float64
+	n    int // Impulse response length in samples.
+
+	head *convStage // Head partitions.
+	tail *convStage // Tail partitions, or nil for short impulse responses.
+	step int        // Head blocks into the current tail block.
+
+	inL, inR   []float32 // Input collected for the next tail block.
+	outL, outR []float32 // The current block of output.
+	pos        int       // Position in the current block.
+
+	delayL, delayR []float32 // Predelay line.
+	delayPos       int
+
+	active bool // True while the reverb is running.
+	idle   int  // Samples since the last non-zero input.
+}
+
+// convStage: Uniformly partitioned overlap-save convolution with a block
+// size of B samples.
+type convStage struct {
+	B     int
+	fft   *FFT
+	nPart int
+
+	hL, hR     [][]complex64 // Impulse response partition spectra.
+	fdlL, fdlR [][]complex64 // Frequency-domain delay line of input spectra.
+	head       int           // Index of the newest spectra in the delay line.
+	accL, accR []complex64   // Output spectra.
+	z          []complex64   // FFT buffer.
+
+	xL, xR     []float32 // The last two blocks of input.
+	outL, outR []float32 // The output for the last block of input.
+}
+
+// loadReverb: Load the impulse response named by Controls.ReverbFile, if
+// any. The reverb itself is created when the sample rate is known.
+func (s *Sampler) loadReverb() error {
+	name := s.controls.ReverbFile
+	if name == "" {
+		return nil
+	}
+
+	path, err := findFile(name)
+	if err != nil {
+		Println("Failed to find impulse response:", name)
+		return err
+	}
+
+	ir, err := LoadSampleFile(path, false)
+	if err != nil {
+		Println("Failed to load impulse response:", path, "\nError:", err)
+		return err
+	}
+
+	Println("Loaded impulse response:", path)
+	s.reverbIR = ir
+	return nil
+}
+
+// NewReverb: Create a reverb for the impulse response at the given output
+// rate. The impulse response must already be at that rate.
+func NewReverb(ir *Sample, rate float64) *Reverb {
+	r := new(Reverb)
+	r.rate = rate
+
+	r.n = ir.Len
+	if max := int(reverbMaxTime * rate); r.n > max {
+		r.n = max
+	}
+
+	headLen := r.n
+	if headLen > reverbHeadLen {
+		headLen = reverbHeadLen
+	}
+	r.head = newConvStage(ir, 0, headLen, reverbBlock)
+	if r.n > reverbHeadLen {
+		r.tail = newConvStage(
+			ir, reverbHeadLen, r.n-reverbHeadLen, reverbTailBlock)
+		r.inL = make([]float32, reverbTailBlock)
+		r.inR = make([]float32, reverbTailBlock)
+	}
+
+	r.outL = make([]float32, reverbBlock)
+	r.outR = make([]float32, reverbBlock)
+
+	nDelay := int(reverbMaxPredelay*rate) + 1
+	r.delayL = make([]float32, nDelay)
+	r.delayR = make([]float32, nDelay)
+
+	return r
+}
+
+// newConvStage: Create a stage convolving with the n samples of ir starting
+// at offset, in blocks of B samples.
+func newConvStage(ir *Sample, offset, n, B int) *convStage {
+	c := new(convStage)
+	c.B = B
+
+	N := 2 * B
+	c.fft = NewFFT(N)
+	c.nPart = (n + B - 1) / B
+	if c.nPart == 0 {
+		c.nPart = 1
+	}
+
+	// Partition spectra. Only bins 0 to B are kept, since the signals are
+	// real. The inverse FFT scaling is applied here.
+	c.hL = make([][]complex64, c.nPart)
+	c.hR = make([][]complex64, c.nPart)
+	scale := float32(1) / float32(N)
+	for p := 0; p < c.nPart; p++ {
+		z := make([]complex64, N)
+		for i := 0; i < B; i++ {
+			if j := p*B + i; j < n {
+				L, R := ir.At(offset + j)
+				z[i] = complex(L*scale, R*scale)
+			}
+		}
+		c.fft.Forward(z)
+		c.hL[p] = make([]complex64, B+1)
+		c.hR[p] = make([]complex64, B+1)
+		unpackSpectra(z, c.hL[p], c.hR[p])
+	}
+
+	c.fdlL = make([][]complex64, c.nPart)
+	c.fdlR = make([][]complex64, c.nPart)
+	for p := range c.fdlL {
+		c.fdlL[p] = make([]complex64, B+1)
+		c.fdlR[p] = make([]complex64, B+1)
+	}
+	c.accL = make([]complex64, B+1)
+	c.accR = make([]complex64, B+1)
+	c.z = make([]complex64, N)
+
+	c.xL = make([]float32, N)
+	c.xR = make([]float32, N)
+	c.outL = make([]float32, B)
+	c.outR = make([]float32, B)
+
+	return c
+}
+
+// unpackSpectra: Split the spectrum of a packed pair of real signals,
+// L + iR, into bins 0 to len(L)-1 of each spectrum.
+func unpackSpectra(z, L, R []complex64) {
+	N := len(z)
+	for k := range L {
+		a := z[k]
+		b := z[(N-k)%N]
+		b = complex(real(b), -imag(b))
+		L[k] = (a + b) * 0.5
+		R[k] = (a - b) * complex(0, -0.5)
+	}
+}
+
+// reset: Clear the reverb state.
+func (r *Reverb) reset() {
+	r.head.reset()
+	if r.tail != nil {
+		r.tail.reset()
+		clear32(r.inL)
+		clear32(r.inR)
+	}
+	clear32(r.outL)
+	clear32(r.outR)
+	clear32(r.delayL)
+	clear32(r.delayR)
+	r.step = 0
+	r.pos = 0
+	r.idle = 0
+}
+
+func (c *convStage) reset() {
+	for p := range c.fdlL {
+		for k := range c.fdlL[p] {
+			c.fdlL[p][k] = 0
+			c.fdlR[p][k] = 0
+		}
+	}
+	clear32(c.xL)
+	clear32(c.xR)
+	clear32(c.outL)
+	clear32(c.outR)
+}
+
+func clear32(x []float32) {
+	for i := range x {
+		x[i] = 0
+	}
+}
+
+// Tail: True while the reverb may still produce output.
+func (r *Reverb) Tail() bool {
+	return r.active && r.idle < r.n+2*reverbTailBlock+len(r.delayL)
+}
+
+// ----------------------------------------------------------------------------
+// Only called from the jack callback.
+
+// processReverb: Add reverb to each output pair.
+func (s *Sampler) processReverb() {
+	for i, r := range s.reverbs {
+		if i >= s.fxPairs() {
+			break
+		}
+		r.process(s.controls, &s.outs[i])
+	}
+}
+
+// process: Add reverb to buf.
+func (r *Reverb) process(c *Controls, buf *Sound) {
+	dry := float32(c.ReverbDry)
+	wet := float32(c.ReverbWet)
+
+	if wet <= 0 {
+		r.active = false
+		if dry != 1 {
+			for i := 0; i < buf.Len; i++ {
+				buf.L[i] *= dry
+				buf.R[i] *= dry
+			}
+		}
+		return
+	}
+
+	if !r.active {
+		r.reset()
+		r.active = true
+	}
+
+	// The block delay counts toward the predelay.
+	delay := int(c.ReverbPredelay*r.rate) - reverbBlock
+	if delay < 0 {
+		delay = 0
+	} else if delay > len(r.delayL)-1 {
+		delay = len(r.delayL) - 1
+	}
+
+	for i := 0; i < buf.Len; i++ {
+		L, R := buf.L[i], buf.R[i]
+		if L != 0 || R != 0 {
+			r.idle = 0
+		} else {
+			r.idle++
+		}
+
+		// Predelay.
+		n := len(r.delayL)
+		r.delayL[r.delayPos] = L
+		r.delayR[r.delayPos] = R
+		j := r.delayPos - delay
+		if j < 0 {
+			j += n
+		}
+		r.delayPos++
+		if r.delayPos == n {
+			r.delayPos = 0
+		}
+
+		r.head.xL[reverbBlock+r.pos] = r.delayL[j]
+		r.head.xR[reverbBlock+r.pos] = r.delayR[j]
+
+		buf.L[i] = dry*L + wet*r.outL[r.pos]
+		buf.R[i] = dry*R + wet*r.outR[r.pos]
+
+		r.pos++
+		if r.pos == reverbBlock {
+			r.processBlock()
+			r.pos = 0
+		}
+	}
+}
+
+// processBlock: Convolve the latest block of input, filling the output
+// block.
+func (r *Reverb) processBlock() {
+	h := r.head
+	h.forward()
+	h.accumulate(0, h.nPart)
+	h.inverse()
+	copy(r.outL, h.outL)
+	copy(r.outR, h.outR)
+
+	if t := r.tail; t != nil {
+		// Work on the previous tail block. Its input is complete at step
+		// zero, and its output is ready at the last step, just in time to
+		// be played from the next head block.
+		switch r.step {
+		case 0:
+			copy(t.xL[t.B:], r.inL)
+			copy(t.xR[t.B:], r.inR)
+			t.forward()
+		case reverbTailSteps - 1:
+			t.inverse()
+		default:
+			// Spread the partitions over the remaining steps.
+			n := reverbTailSteps - 2
+			p0 := t.nPart * (r.step - 1) / n
+			p1 := t.nPart * r.step / n
+			t.accumulate(p0, p1)
+		}
+
+		// Collect the head's last block of input.
+		off := r.step * reverbBlock
+		copy(r.inL[off:], h.xL[:reverbBlock])
+		copy(r.inR[off:], h.xR[:reverbBlock])
+
+		r.step++
+		if r.step == reverbTailSteps {
+			r.step = 0
+		}
+
+		off = r.step * reverbBlock
+		for i := range r.outL {
+			r.outL[i] += t.outL[off+i]
+			r.outR[i] += t.outR[off+i]
+		}
+	}
+}
+
+// forward: Transform the input in x, push its spectra into the delay line,
+// and clear the output spectra. The last block of input is then moved to the
+// start of x.
+func (c *convStage) forward() {
+	N := 2 * c.B
+	z := c.z
+
+	for i := 0; i < N; i++ {
+		z[i] = complex(c.xL[i], c.xR[i])
+	}
+	c.fft.Forward(z)
+
+	// Push the new spectra into the delay line.
+	c.head--
+	if c.head < 0 {
+		c.head = c.nPart - 1
+	}
+	unpackSpectra(z, c.fdlL[c.head], c.fdlR[c.head])
+
+	copy(c.xL[:c.B], c.xL[c.B:])
+	copy(c.xR[:c.B], c.xR[c.B:])
+
+	for k := range c.accL {
+		c.accL[k] = 0
+		c.accR[k] = 0
+	}
+}
+
+// accumulate: Add the products of partitions p0 to p1-1 with their input
+// spectra to the output spectra.
+func (c *convStage) accumulate(p0, p1 int) {
+	idx := (c.head + p0) % c.nPart
+	for p := p0; p < p1; p++ {
+		xL, xR := c.fdlL[idx], c.fdlR[idx]
+		hL, hR := c.hL[p], c.hR[p]
+		accL, accR := c.accL, c.accR
+		for k := range accL {
+			accL[k] += xL[k] * hL[k]
+			accR[k] += xR[k] * hR[k]
+		}
+		if idx++; idx == c.nPart {
+			idx = 0
+		}
+	}
+}
+
+// inverse: Transform the output spectra, filling the output block.
+func (c *convStage) inverse() {
+	B := c.B
+	N := 2 * B
+	z := c.z
+
+	// Pack the outputs as L + iR and rebuild the full spectrum from its
+	// conjugate symmetry.
+	for k := 0; k <= B; k++ {
+		z[k] = c.accL[k] + c.accR[k]*complex(0, 1)
+	}
+	for k := B + 1; k < N; k++ {
+		L, R := c.accL[N-k], c.accR[N-k]
+		L = complex(real(L), -imag(L))
+		R = complex(real(R), -imag(R))
+		z[k] = L + R*complex(0, 1)
+	}
+	c.fft.Inverse(z)
+
+	// Overlap-save: the last block of the circular convolution is valid.
+	for i := 0; i < B; i++ {
+		c.outL[i] = real(z[B+i])
+		c.outR[i] = imag(z[B+i])
+	}
+}
@@ -11,42 +11,138 @@ import (
 // for the small rate changes we're making.
 //
 // Of course it's always possible that I had an error somewhere.
-func InterpLinear16(y1, y2, mu float64) int16 {
-	y := y1*(1-mu) + y2*mu
+//
+// Other kernels can be selected with Controls.Interpolation (see interp.go),
+// and compared using the measure subcommand.
+func InterpLinear(y1, y2, mu float32) float32 {
+	return y1*(1-mu) + y2*mu
+}
+
+// toInt16: Convert a sample scaled to 1.0 max to 16 bits.
+func toInt16(x float32) int16 {
+	y := x * maxVal16
 	if y > maxVal16 {
 		return maxVal16
 	} else if y < -maxVal16 {
 		return -maxVal16
-	} else {
-		return int16(y)
 	}
+	return int16(y)
 }
 
 // ----------------------------------------------------------------------------
+// Samples are stored either as float32 at full precision, or as int16 to save
+// memory. Only one pair of slices is non-nil. Mono samples are stored once in
+// the left channel and the right channel is nil.
 type Sample struct {
-	Rms  float64 // The RMS value of the initial samples.
-	Idx0 int     // Zero index.
-	Len  int     // Number of samples in each channel.
-	L    []int16 // Left channel samples.
-	R    []int16 // Right channel samples.
+	Rms   float64     // The RMS value of the initial samples.
+	Idx0  int         // Zero index.
+	Len   int         // Number of samples in each channel.
+	Bits  int         // Bit depth of the source file.
+	Rate  int         // Sample rate.
+	Mono  bool        // True if the sample has a single channel.
+	Width float32     // Stereo width. 0 is mono, 1 is unchanged.
+	Loop  *SampleLoop // Sustain loop, or nil.
+	L     []float32   // Left channel samples, scaled to 1.0 max.
+	R     []float32   // Right channel samples, scaled to 1.0 max.
+	L16   []int16     // Left channel samples in 16-bit mode.
+	R16   []int16     // Right channel samples in 16-bit mode.
 }
 
-func NewSample(size int) *Sample {
+func NewSample(size int, bits16, mono bool) *Sample {
 	s := new(Sample)
 	s.Len = size
-	s.L = make([]int16, size)
-	s.R = make([]int16, size)
+	s.Mono = mono
+	s.Width = 1
+	s.Rate = sampleRate
+	if bits16 {
+		s.Bits = 16
+		s.L16 = make([]int16, size)
+		if !mono {
+			s.R16 = make([]int16, size)
+		}
+	} else {
+		s.Bits = 32
+		s.L = make([]float32, size)
+		if !mono {
+			s.R = make([]float32, size)
+		}
+	}
 	return s
 }
 
-func NewSampleFromArrays(L, R []int16) *Sample {
+// NewSampleFromArrays: If R is nil, the sample is mono.
+func NewSampleFromArrays(L, R []float32, bits int) *Sample {
 	s := new(Sample)
 	s.Len = len(L)
+	s.Bits = bits
+	s.Rate = sampleRate
+	s.Mono = R == nil
+	s.Width = 1
 	s.L = L
 	s.R = R
 	return s
 }
 
+// NewSampleFromArrays16: If R is nil, the sample is mono.
+func NewSampleFromArrays16(L, R []int16) *Sample {
+	s := new(Sample)
+	s.Len = len(L)
+	s.Bits = 16
+	s.Rate = sampleRate
+	s.Mono = R == nil
+	s.Width = 1
+	s.L16 = L
+	s.R16 = R
+	return s
+}
+
+// Is16: True if the sample is stored in 16 bits.
+func (s *Sample) Is16() bool {
+	return s.L == nil
+}
+
+// newLike: A new sample with the same storage as s.
+func (s *Sample) newLike(size int) *Sample {
+	sNew := NewSample(size, s.Is16(), s.Mono)
+	sNew.Bits = s.Bits
+	sNew.Rate = s.Rate
+	sNew.Width = s.Width
+	sNew.Loop = s.Loop
+	return sNew
+}
+
+// At: Return the L and R samples at index i, scaled to 1.0 max.
+func (s *Sample) At(i int) (float32, float32) {
+	var L float32
+	if s.L != nil {
+		L = s.L[i]
+		if !s.Mono {
+			return L, s.R[i]
+		}
+	} else {
+		L = float32(s.L16[i]) / maxVal16
+		if !s.Mono {
+			return L, float32(s.R16[i]) / maxVal16
+		}
+	}
+	return L, L
+}
+
+// Set: Set the L and R samples at index i. R is ignored for mono samples.
+func (s *Sample) Set(i int, L, R float32) {
+	if s.L != nil {
+		s.L[i] = L
+		if !s.Mono {
+			s.R[i] = R
+		}
+	} else {
+		s.L16[i] = toInt16(L)
+		if !s.Mono {
+			s.R16[i] = toInt16(R)
+		}
+	}
+}
+
 func (s *Sample) Stretched(semitones float64) *Sample {
 	if semitones == 0 {
 		return s
@@ -55,80 +151,139 @@ func (s *Sample) Stretched(semitones float64) *Sample {
 	ratio := math.Pow(2.0, -semitones/12.0)
 	newLen := int(float64(s.Len-1) * ratio)
 
-	sNew := NewSample(newLen)
+	sNew := s.newLike(newLen)
+	sNew.Loop = s.Loop.scaled(ratio, newLen)
 
 	for i := 0; i < newLen; i++ {
 		jf := float64(i) / ratio
 		j := int(jf)
-		mu := jf - float64(j)
-		sNew.L[i] = InterpLinear16(float64(s.L[j]), float64(s.L[j+1]), mu)
-		sNew.R[i] = InterpLinear16(float64(s.R[j]), float64(s.R[j+1]), mu)
+		mu := float32(jf - float64(j))
+		L1, R1 := s.At(j)
+		L2, R2 := s.At(j + 1)
+		sNew.Set(i, InterpLinear(L1, L2, mu), InterpLinear(R1, R2, mu))
 	}
 
 	return sNew
 }
 
+// Resampled: Return the sample resampled to the given rate using r, which
+// must convert from s.Rate to rate.
+func (s *Sample) Resampled(r *Resampler, rate int) *Sample {
+	L := make([]float32, s.Len)
+	R := make([]float32, s.Len)
+	for i := 0; i < s.Len; i++ {
+		L[i], R[i] = s.At(i)
+	}
+
+	L = r.Resample(L)
+	if s.Mono {
+		R = L
+	} else {
+		R = r.Resample(R)
+	}
+
+	sNew := s.newLike(len(L))
+	sNew.Rate = rate
+	sNew.Loop = s.Loop.scaled(float64(rate)/float64(s.Rate), len(L))
+	for i := range L {
+		sNew.Set(i, L[i], R[i])
+	}
+	return sNew
+}
+
 func (s *Sample) FakeLayerRC() *Sample {
-	sNew := NewSample(s.Len)
-	copy(sNew.L, s.L)
-	copy(sNew.R, s.R)
-	sNew.Len = len(sNew.L)
-	rcLowPass(sNew.L, 20.0, 1)
-	rcLowPass(sNew.R, 20.0, 1)
+	L := make([]float32, s.Len)
+	R := make([]float32, s.Len)
+	for i := 0; i < s.Len; i++ {
+		L[i], R[i] = s.At(i)
+	}
+
+	rcLowPass(L, 20.0, 1, s.Rate)
+	if !s.Mono {
+		rcLowPass(R, 20.0, 1, s.Rate)
+	}
+
+	sNew := s.newLike(s.Len)
+	for i := 0; i < s.Len; i++ {
+		sNew.Set(i, L[i], R[i])
+	}
 	return sNew
 }
 
 func (s *Sample) UpdateCropThresh(thresh float64) {
-	th := int16(thresh * maxVal16)
+	s.Idx0 = s.cropIndex(thresh)
+}
+
+func (s *Sample) UpdateRms(rmsTime float64) {
+	s.Rms = s.calcRms(s.Idx0, rmsTime)
+}
+
+// cropIndex: The index of the first frame reaching thresh.
+func (s *Sample) cropIndex(thresh float64) int {
+	th := float32(thresh)
 	var i int
-	
+	var L, R float32
+
 	for i = 0; i < s.Len; i++ {
-		if (s.L[i] >= th || 
-			s.L[i] <= -th || 
-			s.R[i] >= th || 
-			s.R[i] <= -th) {
+		L, R = s.At(i)
+		if L >= th || L <= -th || R >= th || R <= -th {
 			break
 		}
 	}
-	
-	// The reason this is assigned here and not in the loop is to that it's
-	// effectively an atomic change (on x86 anyway). 
-	s.Idx0 = i
+
+	return i
 }
 
-func (s *Sample) UpdateRms(rmsTime float64) {
+// calcRms: The RMS value over rmsTime seconds from idx0.
+func (s *Sample) calcRms(idx0 int, rmsTime float64) float64 {
 	rms := 0.0
 	num := 0.0
-	var x float64
+	var L, R float32
 
-	imin := s.Idx0
-	imax := s.Idx0 + int(sampleRate*rmsTime)
+	imin := idx0
+	imax := idx0 + int(float64(s.Rate)*rmsTime)
 	if imax > s.Len {
 		imax = s.Len
 	}
 
 	for i := imin; i < imax; i++ {
-		x = float64(s.L[i]) / maxVal16
-		rms += x * x
-
-		x = float64(s.R[i]) / maxVal16
-		rms += x * x
-
+		L, R = s.At(i)
+		rms += float64(L)*float64(L) + float64(R)*float64(R)
 		num += 2
 	}
 
 	rms /= num
-	s.Rms = math.Sqrt(rms)
+	return math.Sqrt(rms)
 }
 
 // Return interpolated L and R samples for the given index.
-// Samples are scaled to 1.0 max.
-func (s *Sample) Interp(idx float32) (float32, float32) {
+// Samples are scaled to 1.0 max. Mono samples return the same value for L
+// and R.
+func (s *Sample) Interp(idx float64) (float32, float32) {
 	iIdx := int64(idx)
-	mu := idx - float32(iIdx)
+	mu := float32(idx - float64(iIdx))
 
-	L := (float32(s.L[iIdx])*(1-mu) + float32(s.L[iIdx+1])*mu) / maxVal16
-	R := (float32(s.R[iIdx])*(1-mu) + float32(s.R[iIdx+1])*mu) / maxVal16
+	if s.L != nil {
+		L := s.L[iIdx]*(1-mu) + s.L[iIdx+1]*mu
+		if s.Mono {
+			return L, L
+		}
+		R := s.R[iIdx]*(1-mu) + s.R[iIdx+1]*mu
+		return L, R
+	}
+
+	L := (float32(s.L16[iIdx])*(1-mu) + float32(s.L16[iIdx+1])*mu) / maxVal16
+	if s.Mono {
+		return L, L
+	}
+	R := (float32(s.R16[iIdx])*(1-mu) + float32(s.R16[iIdx+1])*mu) / maxVal16
 
 	return L, R
 }
+
+// applyWidth: Scale the side (L-R) signal by the given width.
+func applyWidth(L, R, width float32) (float32, float32) {
+	mid := (L + R) / 2
+	side := (L - R) / 2 * width
+	return mid + side, mid - side
+}
@@ -1,33 +1,110 @@
 package jlsampler
 
 import (
-	"github.com/johnnylee/jackclient"
+	"math"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-type Sampler struct {
-	mutex        *sync.Mutex
-	controls     *Controls
-	midiListener *MidiListener
-	jackClient   *jackclient.JackClient
-	keySamplers  []*KeySampler // Per key (128).
+const (
+	eventQueueSize = 1024  // Must be a power of two.
+	tauBend        = 0.005 // Pitch bend smoothing time constant in seconds.
+)
 
-	buf *Sound
+type Sampler struct {
+	stolen int64 // Number of stolen voices. First for 64-bit alignment.
+
+	gainReduction uint64 // Limiter gain reduction in dB, as float64 bits.
+	renderTime    int64  // Clock time in nanoseconds while rendering.
+
+	// The latest sample statistics request, as float64 bits.
+	statsCrop uint64
+	statsRms  uint64
+
+	controls    *Controls
+	midi        MidiSource
+	audio       AudioBackend
+	keySamplers []*KeySampler // Per key (128).
+
+	// Events are passed to the jack callback through the queue. Producers
+	// hold pushMutex so that more than one goroutine can send events.
+	pushMutex  *sync.Mutex
+	queue      *EventQueue
+	events     []Event // Events for the current buffer.
+	startTime  time.Time
+	lastTime   int64   // Clock time at the start of the previous buffer.
+	rendering  bool    // The clock is set by Render instead of running.
+	outRate    float64 // Output sample rate.
+	sampleRate int     // Sample rate of the loaded samples.
+
+	buf    *Sound  // The first output pair.
+	outs   []Sound // Output pairs.
+	stereo bool    // Mix the pairs into buf before the master effects.
+	segs   []Sound // Output pairs for the current segment.
 	diBase float32
-	di  []float32
-	amp []float32 
+	di     []float32
+	amp    []float32
+
+	bend       float64 // Pitch bend value from -1 to 1.
+	bendMult   float32 // Current (smoothed) playback rate multiplier.
+	bendSmooth float32 // Per-sample smoothing factor for bendMult.
+
+	voiceSerial uint64 // Serial number of the last voice started.
+
+	frame     int64 // Number of frames rendered.
+	sustain   bool  // Sustain pedal state at the last check.
+	sostenuto bool  // Sostenuto pedal state at the last check.
+
+	pedal     *PedalNoise // Sustain pedal noise.
+	resonance *Resonance  // Sympathetic string resonance.
+	reverbIR  *Sample     // Reverb impulse response, or nil.
+	reverbs   []*Reverb   // A reverb for each output pair, or nil.
+	eqs       []*Eq       // An EQ for each output pair.
+	limiters  []*Limiter  // A limiter for each output pair.
+
+	statsWake chan struct{} // Wakes runSampleStats.
 }
 
-func NewSampler(name, path string) (*Sampler, error) {
+func NewSampler(name, path string, config *Config) (*Sampler, error) {
 	var err error
 
-	// Try to load config.
-	config, err := LoadConfig()
+	s, err := loadSampler(path)
 	if err != nil {
 		return nil, err
 	}
 
+	// Create the midi source.
+	s.midi, err = NewMidiSource(name, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open the audio backend.
+	s.audio, err = NewAudioBackend(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.audio.Open(name, 0, 2*len(s.outs)); err != nil {
+		return nil, err
+	}
+
+	// The file backend writes a single stereo file.
+	s.stereo = config.Audio == "file"
+
+	// Get output sample rate.
+	s.setSampleRate(float64(s.audio.SampleRate()))
+
+	return s, nil
+}
+
+// loadSampler: Load the controls and samples from the given sample-set
+// directory. The returned sampler has no audio or midi connections.
+func loadSampler(path string) (*Sampler, error) {
+	var err error
+
 	// Change to sampler directory.
 	originalDir, _ := os.Getwd()
 	if err = os.Chdir(path); err != nil {
@@ -37,7 +114,12 @@ func NewSampler(name, path string) (*Sampler, error) {
 
 	// New sampler object.
 	s := new(Sampler)
-	s.mutex = new(sync.Mutex)
+	s.pushMutex = new(sync.Mutex)
+	s.queue = NewEventQueue(eventQueueSize)
+	s.events = make([]Event, 0, eventQueueSize)
+	s.startTime = time.Now()
+	s.bendMult = 1
+	s.statsWake = make(chan struct{}, 1)
 
 	// Create controls.
 	s.controls = NewControls(s)
@@ -50,15 +132,8 @@ func NewSampler(name, path string) (*Sampler, error) {
 		return nil, err
 	}
 
-	// Create midiListener.
-	s.midiListener, err = NewMidiListener(s, name, config.MidiIn)
-	if err != nil {
-		return nil, err
-	}
-
-	// The buffer doesn't need any size because it will use the
+	// The buffers don't need any size because they will use the
 	// slices passed in by the jack callback.
-	s.buf = NewSound(0)
 
 	// Make key samplers.
 	s.keySamplers = make([]*KeySampler, 128)
@@ -68,29 +143,91 @@ func NewSampler(name, path string) (*Sampler, error) {
 		return nil, err
 	}
 
-	// Update crop threshold. This will also update the RMS value.
-	s.UpdateCropThresh()
+	if err = s.loadPedalNoise(); err != nil {
+		return nil, err
+	}
 
-	// Create jackClient.
-	s.jackClient, err = jackclient.New(name, 0, 2)
-	if err != nil {
+	if err = s.loadReverb(); err != nil {
 		return nil, err
 	}
 
-	// Get output sample rate. 
-	s.diBase = sampleRate / float32(s.jackClient.GetSampleRate())
+	if err = s.loadTunings(); err != nil {
+		return nil, err
+	}
+
+	s.routeOutputs()
+
+	go s.runSampleStats()
 
 	return s, nil
 }
 
+// setSampleRate: Set the output sample rate. Samples are all stored at one
+// rate: the fixed sample rate, or the output rate if Controls.Resample is
+// set. Samples at other rates are resampled here.
+func (s *Sampler) setSampleRate(rate float64) {
+	s.sampleRate = sampleRate
+	if s.controls.Resample {
+		s.sampleRate = int(rate)
+	}
+	s.resampleSamples(s.sampleRate)
+
+	// Update crop threshold. This will also update the RMS value.
+	s.UpdateCropThresh()
+
+	s.outRate = rate
+	s.controls.outRate = rate
+	s.controls.NLoopXfade = float32(
+		s.controls.LoopXfade * float64(s.sampleRate))
+	s.diBase = float32(s.sampleRate) / float32(rate)
+	s.bendSmooth = float32(1 - math.Exp(-1/(rate*tauBend)))
+	s.resonance = NewResonance(rate)
+	s.eqs = make([]*Eq, len(s.outs))
+	s.limiters = make([]*Limiter, len(s.outs))
+	for i := range s.outs {
+		s.eqs[i] = NewEq(rate)
+		s.limiters[i] = NewLimiter(rate)
+	}
+
+	s.reverbs = nil
+	if ir := s.reverbIR; ir != nil {
+		if ir.Rate != int(rate) {
+			ir = ir.Resampled(NewResampler(ir.Rate, int(rate)), int(rate))
+		}
+		for range s.outs {
+			s.reverbs = append(s.reverbs, NewReverb(ir, rate))
+		}
+	}
+}
+
 func (s *Sampler) Run() {
-	go s.midiListener.Run()
-	s.jackClient.RegisterCallback(s.JackProcess)
-	s.controls.Run()
+	s.audio.RegisterCallback(s.JackProcess)
+
+	// When replaying from stdin there's no command input. Run until the
+	// replay is finished.
+	if rs, ok := s.midi.(*ReplaySource); ok && rs.UsesStdin() {
+		s.runMidi()
+	} else {
+		go s.runMidi()
+		s.controls.Run()
+	}
+
+	if err := s.audio.Close(); err != nil {
+		Println("Error closing audio backend:", err)
+	}
+}
+
+func (s *Sampler) runMidi() {
+	if err := s.midi.Run(s.MidiEvent); err != nil {
+		Println("Error reading midi:", err)
+	}
 }
 
 // ----------------------------------------------------------------------------
 // These functions run slowly and could cause skipping.
+// UpdateCropThresh: Crop the samples and compute their RMS values. Only
+// called before the audio starts. Later changes go through
+// requestSampleStats.
 func (s *Sampler) UpdateCropThresh() {
 	for _, ks := range s.keySamplers {
 		if ks != nil {
@@ -100,83 +237,265 @@ func (s *Sampler) UpdateCropThresh() {
 	}
 }
 
-func (s *Sampler) UpdateRms() {
-	for _, ks := range s.keySamplers {
-		if ks != nil {
-			ks.UpdateRms(s.controls.RmsTime)
+// ----------------------------------------------------------------------------
+// Sample statistics. Cropping and RMS are too slow for the jack callback, so
+// they're computed by runSampleStats and the results are applied by an event.
+
+// sampleStats: A sample's crop index and RMS value.
+type sampleStats struct {
+	sample *Sample
+	idx0   int
+	rms    float64
+}
+
+// requestSampleStats: Recompute the crop indices and RMS values for the
+// current controls. Called from the jack callback, so it doesn't block.
+// Requests are coalesced, and the latest always wins.
+func (s *Sampler) requestSampleStats() {
+	atomic.StoreUint64(&s.statsCrop, math.Float64bits(s.controls.CropThresh))
+	atomic.StoreUint64(&s.statsRms, math.Float64bits(s.controls.RmsTime))
+	select {
+	case s.statsWake <- struct{}{}:
+	default:
+		// Already pending. The request will see the new values.
+	}
+}
+
+// runSampleStats: Serve requests from requestSampleStats. Only the sample
+// data is read here. Idx0 and Rms are only written in the jack callback.
+func (s *Sampler) runSampleStats() {
+	for range s.statsWake {
+		cropThresh := math.Float64frombits(atomic.LoadUint64(&s.statsCrop))
+		rmsTime := math.Float64frombits(atomic.LoadUint64(&s.statsRms))
+
+		var stats []sampleStats
+		for _, ks := range s.keySamplers {
+			if ks == nil {
+				continue
+			}
+			for _, sl := range ks.noteLayers() {
+				for _, sample := range sl.samples {
+					idx0 := sample.cropIndex(cropThresh)
+					stats = append(stats, sampleStats{
+						sample, idx0, sample.calcRms(idx0, rmsTime)})
+				}
+			}
 		}
+
+		s.ControlEvent(func(float64) { applySampleStats(stats) }, 0)
+	}
+}
+
+// applySampleStats: Only called from the jack callback.
+func applySampleStats(stats []sampleStats) {
+	for _, st := range stats {
+		st.sample.Idx0 = st.idx0
+		st.sample.Rms = st.rms
 	}
 }
 
 // ----------------------------------------------------------------------------
-// Functions below protected by mutex.
-func (s *Sampler) NoteOnEvent(note int8, value float64) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	note += s.controls.Transpose
-	if note > 0 && note < 127 {
-		if s.keySamplers[note] != nil {
-			s.keySamplers[note].NoteOn(value)
-		}
+// Event functions. These may be called from any goroutine. The events are
+// queued and applied in the jack callback.
+func (s *Sampler) clock() int64 {
+	if s.rendering {
+		return atomic.LoadInt64(&s.renderTime)
 	}
+	return int64(time.Since(s.startTime))
 }
 
-func (s *Sampler) NoteOffEvent(note int8, value float64) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	note += s.controls.Transpose
-	if note > 0 && note < 127 {
-		if s.keySamplers[note] != nil {
-			s.keySamplers[note].NoteOff()
-		}
+func (s *Sampler) pushEvent(ev Event) {
+	s.pushMutex.Lock()
+	defer s.pushMutex.Unlock()
+	ev.Time = s.clock()
+	if !s.queue.Push(ev) {
+		Println("Event queue full. Dropping event.")
 	}
 }
 
+func (s *Sampler) NoteOnEvent(note int8, value float64) {
+	s.pushEvent(Event{Type: EventNoteOn, Note: note, Value: value})
+}
+
+func (s *Sampler) NoteOffEvent(note int8, value float64) {
+	s.pushEvent(Event{Type: EventNoteOff, Note: note, Value: value})
+}
+
 func (s *Sampler) ControllerEvent(control int32, value float64) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	s.controls.ProcessMidi(control, value)
+	s.pushEvent(Event{Type: EventController, Control: control, Value: value})
 }
 
+// PitchBendEvent: value is the bend from -1 (full down) to 1 (full up).
 func (s *Sampler) PitchBendEvent(value float64) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-	// TODO.
+	s.pushEvent(Event{Type: EventPitchBend, Value: value})
 }
 
-// Jack processing callback.
-func (s *Sampler) JackProcess(bufIn, bufOut [][]float32) error {
-	// Can we just remove this lock? We'll see. 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// ControlEvent: Call the given control update function from the jack
+// callback.
+func (s *Sampler) ControlEvent(update func(float64), value float64) {
+	s.pushEvent(Event{Type: EventControl, Update: update, Value: value})
+}
 
-	s.buf.L = bufOut[0]
-	s.buf.R = bufOut[1]
-	s.buf.Len = len(s.buf.L)
+// ----------------------------------------------------------------------------
+// Functions below are only called from the jack callback.
+func (s *Sampler) applyEvent(ev *Event) {
+	switch ev.Type {
+	case EventNoteOn:
+		note := ev.Note + s.controls.Transpose
+		if note > 0 && note < 127 && s.keySamplers[note] != nil {
+			s.stealVoices(int(note))
+			ps := s.keySamplers[note].NoteOn(ev.Value, s.now())
+			ps.serial = s.nextSerial()
+		}
 
-	if len(s.di) != s.buf.Len {
-		s.di = make([]float32, s.buf.Len)
-		s.amp = make([]float32, s.buf.Len)
+	case EventNoteOff:
+		note := ev.Note + s.controls.Transpose
+		if note > 0 && note < 127 && s.keySamplers[note] != nil {
+			s.keySamplers[note].NoteOff(s.now())
+		}
+
+	case EventController:
+		s.controls.ProcessMidi(ev.Control, ev.Value)
+
+	case EventPitchBend:
+		s.bend = ev.Value
+
+	case EventControl:
+		ev.Update(ev.Value)
+
+	case EventProgramChange:
+		if f := s.controls.programControl; f != nil {
+			f(float64(ev.Control))
+		}
+
+	case EventTuning:
+		s.retune(ev.Tuning)
+	}
+}
+
+// nextSerial: The serial number for a new voice. Every voice gets one when
+// it starts, so that the oldest voices can be stolen first.
+func (s *Sampler) nextSerial() uint64 {
+	s.voiceSerial++
+	return s.voiceSerial
+}
+
+// setSostenuto: Hold the keys that are down when the sostenuto pedal is
+// pressed. All keys are freed when it lifts.
+func (s *Sampler) setSostenuto(on bool) {
+	for _, ks := range s.keySamplers {
+		if ks != nil {
+			ks.sostenuto = on && ks.on
+		}
 	}
+}
 
-	for i := 0; i < s.buf.Len; i++ {
-		s.buf.L[i] = 0
-		s.buf.R[i] = 0
+// now: The time in seconds of the current frame.
+func (s *Sampler) now() float64 {
+	return float64(s.frame) / s.outRate
+}
+
+// readEvents: Drain the event queue, converting event times to frame offsets
+// in a buffer of length n. Events are delayed by one buffer so that they can
+// be placed relative to the start of the previous buffer.
+func (s *Sampler) readEvents(n int) {
+	now := s.clock()
+	s.events = s.events[:0]
+
+	var ev Event
+	for s.queue.Pop(&ev) {
+		offset := int64(float64(ev.Time-s.lastTime) * s.outRate / 1e9)
+		if offset < 0 {
+			offset = 0
+		} else if offset > int64(n-1) {
+			offset = int64(n - 1)
+		}
+		ev.Frame = int(offset)
+		s.events = append(s.events, ev)
 	}
 
-	// Note: di and amp are set here so that in the future these contorls
-	// can be handled by a more sophisticated code to allow the values 
-	// to be smoothed in time. 
-	for i, _ := range s.di {
-		s.amp[i] = float32(s.controls.Amp)
-		s.di[i] = s.diBase
+	s.lastTime = now
+}
+
+// writeOutput: Render frames start through end-1 of the output buffer.
+func (s *Sampler) writeOutput(start, end int) {
+	if start == end {
+		return
 	}
 
+	// The pitch bend multiplier is smoothed sample-by-sample to avoid
+	// zipper noise.
+	semitones := s.bend * float64(s.controls.PitchBendMax)
+	bendMult := float32(math.Pow(2, semitones/12))
+	amp := float32(s.controls.Amp)
+
+	for i := start; i < end; i++ {
+		s.bendMult += s.bendSmooth * (bendMult - s.bendMult)
+		s.amp[i] = amp
+		s.di[i] = s.diBase * s.bendMult
+	}
+
+	s.frame += int64(end - start)
+
+	for i := range s.outs {
+		out := &s.outs[i]
+		s.segs[i] = Sound{end - start, out.L[start:end], out.R[start:end]}
+	}
+	amps := s.amp[start:end]
+	di := s.di[start:end]
+
 	for _, ks := range s.keySamplers {
 		if ks != nil && ks.HasData() {
-			ks.WriteOutput(s.buf, s.amp, s.di)
+			ks.WriteOutput(&s.segs[ks.out], &s.segs[ks.releaseOut], amps, di)
 		}
 	}
 
+	if s.pedal != nil && s.pedal.HasData() {
+		s.pedal.WriteOutput(&s.segs[0], amps, di)
+	}
+}
+
+// Jack processing callback.
+func (s *Sampler) JackProcess(bufIn, bufOut [][]float32) error {
+	s.readEvents(len(bufOut[0]))
+	s.process(bufOut)
 	return nil
 }
+
+// process: Render one buffer, applying the events in s.events at their
+// frame offsets.
+func (s *Sampler) process(bufOut [][]float32) {
+	for i := range s.outs {
+		out := &s.outs[i]
+		out.L = bufOut[2*i]
+		out.R = bufOut[2*i+1]
+		out.Len = len(out.L)
+		out.Zero()
+	}
+
+	if len(s.di) != s.buf.Len {
+		s.di = make([]float32, s.buf.Len)
+		s.amp = make([]float32, s.buf.Len)
+	}
+
+	// Render the buffer in segments, applying each event at its offset.
+	pos := 0
+	for i := range s.events {
+		ev := &s.events[i]
+		s.writeOutput(pos, ev.Frame)
+		pos = ev.Frame
+		s.applyEvent(ev)
+		s.checkSustainRelease()
+		ev.Update = nil
+	}
+	s.writeOutput(pos, s.buf.Len)
+
+	if s.stereo {
+		s.mixDown()
+	}
+
+	s.processResonance()
+	s.processReverb()
+	s.processEq()
+	s.processLimiters()
+}
@@ -12,13 +12,23 @@ import (
 )
 
 // ----------------------------------------------------------------------------
-func samplePaths(key int) []string {
-	glob := fmt.Sprintf("samples/on-%03d-*.flac", key)
-	paths, err := filepath.Glob(glob)
+// samplePaths: Paths for the given prefix, "on" for note samples, "soft" for
+// soft pedal samples or "off" for release samples.
+func samplePaths(prefix string, key int) []string {
+	glob := fmt.Sprintf("samples/%s-%03d-*", prefix, key)
+	matches, err := filepath.Glob(glob)
 	if err != nil {
 		return []string{}
 	}
 
+	// Keep only files we can load. Formats may be mixed.
+	paths := make([]string, 0, len(matches))
+	for _, path := range matches {
+		if isSampleFile(path) {
+			paths = append(paths, path)
+		}
+	}
+
 	sort.Strings(paths)
 
 	return paths
@@ -52,12 +62,14 @@ func samplePathInfo(path string) (int, int, int, error) {
 // ----------------------------------------------------------------------------
 func (s *Sampler) loadSamples() error {
 	tuningFile := LoadTuningFile()
+	widthFile := LoadWidthFile()
+	loopFile := LoadLoopFile()
 	wg := new(sync.WaitGroup)
 
 	ok := true
 	for key := 0; key < 128; key++ {
 		wg.Add(1)
-		go s.loadKey(key, tuningFile, &ok, wg)
+		go s.loadKey(key, tuningFile, widthFile, loopFile, &ok, wg)
 	}
 	wg.Wait()
 
@@ -72,12 +84,13 @@ func (s *Sampler) loadSamples() error {
 }
 
 func (s *Sampler) loadKey(
-	key int, tuningFile *TuningFile, ok *bool, wg *sync.WaitGroup) {
+	key int, tuningFile *TuningFile, widthFile *WidthFile,
+	loopFile *LoopFile, ok *bool, wg *sync.WaitGroup) {
 
 	defer wg.Done()
 
 	// Get paths for the files in each sample layer.
-	paths := samplePaths(key)
+	paths := samplePaths("on", key)
 	if len(paths) == 0 {
 		return
 	}
@@ -87,26 +100,33 @@ func (s *Sampler) loadKey(
 
 	// Loop through paths, loading samples.
 	for _, path := range paths {
-		_, layer, _, err := samplePathInfo(path)
+		layer, sample, err := s.loadPath(path, tuningFile, widthFile, loopFile)
 		if err != nil {
-			Println("Failed to get sample info:", path)
 			*ok = false
 			return
 		}
-		
-		sample, err := LoadFlac(path)
+		s.loadKeySample(sample, layer, ks)
+	}
+
+	// Soft pedal samples.
+	for _, path := range samplePaths("soft", key) {
+		layer, sample, err := s.loadPath(path, tuningFile, widthFile, loopFile)
 		if err != nil {
-			Println("Failed to load sample:", path, "\nError:", err)
 			*ok = false
 			return
 		}
+		ks.AddSoftSample(sample, layer)
+	}
+	ks.fillSoftLayers()
 
-		semitones := tuningFile.GetTuning(path)
-		if semitones != 0 {
-			sample = sample.Stretched(semitones)
+	// Release samples.
+	for _, path := range samplePaths("off", key) {
+		layer, sample, err := s.loadPath(path, tuningFile, widthFile, loopFile)
+		if err != nil {
+			*ok = false
+			return
 		}
-
-		s.loadKeySample(sample, layer, ks)
+		ks.AddReleaseSample(sample, layer)
 	}
 
 	Println("Loaded key:", key)
@@ -114,6 +134,42 @@ func (s *Sampler) loadKey(
 	runtime.GC() // Force garbage collection here? 
 }
 
+// loadPath: Load the sample at path, returning its layer.
+func (s *Sampler) loadPath(
+	path string, tuningFile *TuningFile, widthFile *WidthFile,
+	loopFile *LoopFile) (int, *Sample, error) {
+
+	_, layer, _, err := samplePathInfo(path)
+	if err != nil {
+		Println("Failed to get sample info:", path)
+		return 0, nil, err
+	}
+
+	sample, err := LoadSampleFile(path, s.controls.Bits16)
+	if err != nil {
+		Println("Failed to load sample:", path, "\nError:", err)
+		return 0, nil, err
+	}
+
+	// Loop points refer to the file, so they're set before stretching.
+	if loop := loopFile.GetLoop(path); loop != nil {
+		if !loop.valid(sample.Len) {
+			Println("Invalid loop for sample:", path)
+			return 0, nil, errors.New("Invalid loop: " + path)
+		}
+		sample.Loop = loop
+	}
+
+	semitones := tuningFile.GetTuning(path)
+	if semitones != 0 {
+		sample = sample.Stretched(semitones)
+	}
+
+	sample.Width = float32(widthFile.GetWidth(path))
+
+	return layer, sample, nil
+}
+
 func (s *Sampler) loadKeySample(sample *Sample, layer int, ks *KeySampler) {
 	if s.controls.FakeLayerRC { 
 		// Generate fake layer. 
@@ -224,3 +280,80 @@ func (s *Sampler) fillTransposeSamples() {
 	}
 	wg.Wait()
 }
+
+// allLayers: All sample layers, including pedal noise.
+func (s *Sampler) allLayers() []*SampleLayer {
+	var all []*SampleLayer
+	for _, ks := range s.keySamplers {
+		if ks != nil {
+			all = append(all, ks.allLayers()...)
+		}
+	}
+	if s.pedal != nil {
+		all = append(all, s.pedal.down, s.pedal.up)
+	}
+	return all
+}
+
+// resampleSamples: Resample any samples that aren't at the given rate. The
+// resampling is slow, so it's done in parallel.
+func (s *Sampler) resampleSamples(rate int) {
+	resampled := make(map[*Sample]*Sample)
+
+	// Samples may be shared between layers, so find the unique samples
+	// first.
+	for _, sl := range s.allLayers() {
+		for _, sample := range sl.samples {
+			if sample.Rate != rate {
+				resampled[sample] = nil
+			}
+		}
+	}
+
+	if len(resampled) == 0 {
+		return
+	}
+
+	Println("Resampling", len(resampled), "samples to", rate, "Hz.")
+
+	// One resampler per input rate.
+	samples := make([]*Sample, 0, len(resampled))
+	resamplers := make(map[int]*Resampler)
+	for sample := range resampled {
+		samples = append(samples, sample)
+		if resamplers[sample.Rate] == nil {
+			resamplers[sample.Rate] = NewResampler(sample.Rate, rate)
+		}
+	}
+
+	mutex := new(sync.Mutex)
+	work := make(chan *Sample)
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sample := range work {
+				sNew := sample.Resampled(resamplers[sample.Rate], rate)
+				mutex.Lock()
+				resampled[sample] = sNew
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, sample := range samples {
+		work <- sample
+	}
+	close(work)
+	wg.Wait()
+
+	for _, sl := range s.allLayers() {
+		for i, sample := range sl.samples {
+			if sNew, ok := resampled[sample]; ok {
+				sl.samples[i] = sNew
+			}
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package jlsampler
+
+import (
+	"bufio"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// Scala tunings. A tuning is a scale (.scl) with an optional keyboard
+// mapping (.kbm). See http://www.huygens-fokker.org/scala/scl_format.html
+// and http://www.huygens-fokker.org/scala/help.htm#mappings.
+//
+// Keys are retuned through the playback rate of the voices. The samples are
+// assumed to be in 12-tone equal temperament at A440.
+
+// TuningSpec: A tuning in defaults.js. Files are looked up in the
+// sample-set directory and then in ~/.jlsampler. If there's no mapping
+// file, a linear mapping is used, with key 60 on the first degree and key 69
+// at 440 Hz.
+type TuningSpec struct {
+	Scale string // Scala scale file.
+	Map   string // Keyboard mapping file. May be empty.
+}
+
+// Tuning: Per-key pitch offsets in semitones from equal temperament.
+type Tuning struct {
+	Name    string
+	offsets [128]float64
+}
+
+// Offset: The offset for the key in semitones.
+func (t *Tuning) Offset(key int) float64 {
+	if t == nil || key < 0 || key > 127 {
+		return 0
+	}
+	return t.offsets[key]
+}
+
+// scalaLines: The non-comment lines of a Scala file, trimmed.
+func scalaLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// firstField: The first whitespace separated field of a line. Scala allows
+// text after values.
+func firstField(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// parseScalaPitch: Parse a pitch in cents (with a period) or as a ratio.
+func parseScalaPitch(s string) (float64, error) {
+	if strings.Contains(s, ".") {
+		return strconv.ParseFloat(s, 64)
+	}
+
+	num, den := s, "1"
+	if i := strings.Index(s, "/"); i >= 0 {
+		num, den = s[:i], s[i+1:]
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, err
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 || d <= 0 {
+		return 0, errors.New("Invalid ratio: " + s)
+	}
+	return 1200 * math.Log2(n/d), nil
+}
+
+// ----------------------------------------------------------------------------
+// scalaScale: Scale degrees in cents. Degree 0 is 0 cents and isn't
+// stored. The last degree is the period, usually the octave.
+type scalaScale struct {
+	cents []float64
+}
+
+func loadScalaScale(path string) (*scalaScale, error) {
+	lines, err := scalaLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first line is the description, which may be empty.
+	if len(lines) < 2 {
+		return nil, errors.New("Scale file too short: " + path)
+	}
+
+	n, err := strconv.Atoi(firstField(lines[1]))
+	if err != nil || n < 1 {
+		return nil, errors.New("Invalid scale size: " + path)
+	}
+
+	lines = lines[2:]
+	if len(lines) < n {
+		return nil, errors.New("Missing scale degrees: " + path)
+	}
+
+	sc := new(scalaScale)
+	for _, line := range lines[:n] {
+		c, err := parseScalaPitch(firstField(line))
+		if err != nil {
+			return nil, errors.New("Invalid pitch in " + path + ": " + line)
+		}
+		sc.cents = append(sc.cents, c)
+	}
+
+	return sc, nil
+}
+
+// degree: The pitch in cents of any degree, extending the scale by its
+// period in both directions.
+func (sc *scalaScale) degree(d int) float64 {
+	n := len(sc.cents)
+	oct, i := floorDiv(d, n)
+	c := float64(oct) * sc.cents[n-1]
+	if i > 0 {
+		c += sc.cents[i-1]
+	}
+	return c
+}
+
+func floorDiv(a, b int) (int, int) {
+	q, r := a/b, a%b
+	if r < 0 {
+		q--
+		r += b
+	}
+	return q, r
+}
+
+// ----------------------------------------------------------------------------
+// scalaMap: A keyboard mapping.
+type scalaMap struct {
+	first, last int     // Range of mapped keys.
+	middle      int     // Key for degree 0.
+	ref         int     // Reference key.
+	refFreq     float64 // Frequency of the reference key.
+	octave      int     // Degree of the formal octave.
+	degrees     []int   // Degree for each key in the pattern, or -1.
+}
+
+// linearMap: The default mapping for a scale.
+func linearMap() *scalaMap {
+	return &scalaMap{0, 127, 60, 69, 440, 0, nil}
+}
+
+func loadScalaMap(path string) (*scalaMap, error) {
+	lines, err := scalaLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var vals []string
+	for _, line := range lines {
+		if line != "" {
+			vals = append(vals, firstField(line))
+		}
+	}
+	if len(vals) < 7 {
+		return nil, errors.New("Mapping file too short: " + path)
+	}
+
+	ints := make([]int, 7)
+	for i, v := range vals[:7] {
+		if i == 5 {
+			continue
+		}
+		if ints[i], err = strconv.Atoi(v); err != nil {
+			return nil, errors.New("Invalid value in " + path + ": " + v)
+		}
+	}
+
+	km := new(scalaMap)
+	size := ints[0]
+	km.first, km.last, km.middle, km.ref = ints[1], ints[2], ints[3], ints[4]
+	km.octave = ints[6]
+	if km.refFreq, err = strconv.ParseFloat(vals[5], 64); err != nil ||
+		km.refFreq <= 0 {
+		return nil, errors.New("Invalid reference frequency: " + path)
+	}
+	if size < 0 {
+		return nil, errors.New("Invalid mapping size: " + path)
+	}
+
+	// Missing entries at the end are unmapped.
+	vals = vals[7:]
+	for i := 0; i < size; i++ {
+		d := -1
+		if i < len(vals) && vals[i] != "x" {
+			if d, err = strconv.Atoi(vals[i]); err != nil {
+				return nil, errors.New("Invalid degree in " + path)
+			}
+		}
+		km.degrees = append(km.degrees, d)
+	}
+
+	return km, nil
+}
+
+// cents: The pitch of the key in cents relative to the middle key, or false
+// if the key isn't mapped.
+func (km *scalaMap) cents(sc *scalaScale, key int) (float64, bool) {
+	if key < km.first || key > km.last {
+		return 0, false
+	}
+
+	if len(km.degrees) == 0 {
+		return sc.degree(key - km.middle), true
+	}
+
+	oct, i := floorDiv(key-km.middle, len(km.degrees))
+	d := km.degrees[i]
+	if d < 0 {
+		return 0, false
+	}
+
+	period := sc.degree(len(sc.cents))
+	if km.octave > 0 {
+		period = sc.degree(km.octave)
+	}
+	return float64(oct)*period + sc.degree(d), true
+}
+
+// ----------------------------------------------------------------------------
+// LoadTuning: Load a scale and mapping. Unmapped keys are left in equal
+// temperament.
+func LoadTuning(spec TuningSpec) (*Tuning, error) {
+	path, err := findFile(spec.Scale)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := loadScalaScale(path)
+	if err != nil {
+		return nil, err
+	}
+
+	km := linearMap()
+	name := filepath.Base(spec.Scale)
+	if spec.Map != "" {
+		if path, err = findFile(spec.Map); err != nil {
+			return nil, err
+		}
+		if km, err = loadScalaMap(path); err != nil {
+			return nil, err
+		}
+		name += " " + filepath.Base(spec.Map)
+	}
+
+	refCents, ok := km.cents(sc, km.ref)
+	if !ok {
+		return nil, errors.New("Reference key isn't mapped: " + spec.Map)
+	}
+
+	t := new(Tuning)
+	t.Name = name
+	for key := range t.offsets {
+		c, ok := km.cents(sc, key)
+		if !ok {
+			continue
+		}
+		freq := km.refFreq * math.Pow(2, (c-refCents)/1200)
+		t.offsets[key] = 12*math.Log2(freq/440) - float64(key-69)
+	}
+
+	return t, nil
+}
+
+// loadTunings: Load the tunings listed in Controls.Tunings.
+func (s *Sampler) loadTunings() error {
+	c := s.controls
+	c.tunings = make([]*Tuning, 0, len(c.Tunings))
+	for _, spec := range c.Tunings {
+		t, err := LoadTuning(spec)
+		if err != nil {
+			Println("Failed to load tuning:", spec.Scale, "\nError:", err)
+			return err
+		}
+		Println("Loaded tuning:", t.Name)
+		c.tunings = append(c.tunings, t)
+	}
+
+	if c.Tuning < 0 || c.Tuning > len(c.tunings) {
+		Println("Unknown tuning:", c.Tuning)
+		c.Tuning = 0
+	}
+	return nil
+}
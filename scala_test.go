@@ -0,0 +1,154 @@
+package jlsampler
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLoadTuningEqual(t *testing.T) {
+	tuning, err := LoadTuning(TuningSpec{"testdata/12tet.scl", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := 0; key < 128; key++ {
+		if x := tuning.Offset(key); math.Abs(x) > 1e-9 {
+			t.Errorf("Key %d: offset %v, want 0.", key, x)
+		}
+	}
+}
+
+func TestLoadTuning(t *testing.T) {
+	// Semitones of a just interval above C, relative to equal temperament.
+	just := func(ratio float64, semis int) float64 {
+		return 12*math.Log2(ratio) - float64(semis)
+	}
+	// Key 69 is the reference, so the just offsets are relative to 5/3.
+	justA := just(5.0/3, 9)
+	a432 := 12 * math.Log2(432.0/440)
+
+	tet := TuningSpec{"testdata/12tet.scl", ""}
+	ji := TuningSpec{"testdata/just.scl", ""}
+	white := TuningSpec{"testdata/12tet.scl", "testdata/white432.kbm"}
+
+	tests := []struct {
+		name string
+		spec TuningSpec
+		key  int
+		want float64
+	}{
+		{"12-TET A440", tet, 69, 0},
+		{"just C", ji, 60, just(1, 0) - justA},
+		{"just E", ji, 64, just(5.0/4, 4) - justA},
+		{"just A", ji, 69, 0},
+		{"just low E", ji, 40, just(5.0/4, 4) - justA},
+		{"just high B", ji, 107, just(15.0/8, 11) - justA},
+		{"432 C", white, 60, a432},
+		{"432 A", white, 69, a432},
+		{"432 low B", white, 47, a432},
+		{"432 unmapped C#", white, 61, 0},
+		{"432 unmapped Bb", white, 70, 0},
+		{"432 unmapped low F#", white, 30, 0},
+	}
+
+	for _, test := range tests {
+		tuning, err := LoadTuning(test.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := tuning.Offset(test.key); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: key %d offset %v, want %v.",
+				test.name, test.key, got, test.want)
+		}
+	}
+}
+
+func TestLoadScalaMap(t *testing.T) {
+	km, err := loadScalaMap("testdata/white432.kbm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{0, -1, 2, -1, 4, 5, -1, 7, -1, 9, -1, 11}
+	if len(km.degrees) != len(want) {
+		t.Fatalf("%d degrees, want %d.", len(km.degrees), len(want))
+	}
+	for i, d := range want {
+		if km.degrees[i] != d {
+			t.Errorf("Degree %d: %d, want %d.", i, km.degrees[i], d)
+		}
+	}
+	if km.refFreq != 432 || km.ref != 69 || km.middle != 60 {
+		t.Errorf("Reference: key %d at %v, middle %d.",
+			km.ref, km.refFreq, km.middle)
+	}
+}
+
+func TestParseScalaPitch(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"100.0", 100, true},
+		{"-50.5", -50.5, true},
+		{"200.", 200, true},
+		{"2/1", 1200, true},
+		{"2", 1200, true},
+		{"3/2", 1200 * math.Log2(1.5), true},
+		{"0/1", 0, false},
+		{"3/0", 0, false},
+		{"abc", 0, false},
+	}
+
+	for _, test := range tests {
+		got, err := parseScalaPitch(test.in)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: error %v.", test.in, err)
+			continue
+		}
+		if test.ok && math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%q: %v, want %v.", test.in, got, test.want)
+		}
+	}
+}
+
+// Selecting a tuning retunes sounding voices. Program changes only select
+// tunings when bound, and don't clear MTS tuning.
+func TestSelectTuning(t *testing.T) {
+	s := newTestSampler()
+	c := s.controls
+	tuning, err := LoadTuning(TuningSpec{"testdata/12tet.scl", "testdata/white432.kbm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.tunings = []*Tuning{tuning}
+
+	s.applyEvent(&Event{Type: EventNoteOn, Note: 60, Value: 1})
+	ps := s.keySamplers[60].playing[0]
+
+	s.applyEvent(&Event{Type: EventProgramChange, Control: 1})
+	if c.Tuning != 0 || ps.rate != 1 {
+		t.Fatal("Unbound program change selected a tuning.")
+	}
+
+	mts, _ := parseMts([]byte{0xf0, 0x7f, 0x7f, 0x08, 0x02, 0x00, 0x01,
+		62, 63, 0x00, 0x00, 0xf7})
+	s.retune(mts)
+
+	if err := c.bindProgram("Tuning"); err != nil {
+		t.Fatal(err)
+	}
+	s.applyEvent(&Event{Type: EventProgramChange, Control: 1})
+	want := float32(432.0 / 440)
+	if c.Tuning != 1 || math.Abs(float64(ps.rate-want)) > 1e-6 {
+		t.Fatalf("Tuning %d, rate %v, want %v.", c.Tuning, ps.rate, want)
+	}
+	if x, ok := c.mts.Offset(62); !ok || x != 1 {
+		t.Fatal("Program change cleared the MTS tuning.")
+	}
+
+	c.UpdateMtsReset(1)
+	if _, ok := c.mts.Offset(62); ok {
+		t.Fatal("MtsReset didn't clear the MTS tuning.")
+	}
+}
@@ -0,0 +1,219 @@
+package jlsampler
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"sort"
+)
+
+// ----------------------------------------------------------------------------
+// MidiFileEvent: A midi event with a time stamp, read from a file.
+type MidiFileEvent struct {
+	MidiEvent
+	Time float64 // Time in seconds from the start of the file.
+}
+
+// smfEvent: A raw event before conversion to seconds.
+type smfEvent struct {
+	tick  int64
+	tempo int // Microseconds per quarter note, or 0 if not a tempo change.
+	ev    MidiFileEvent
+	keep  bool // True if ev should be returned.
+}
+
+// ----------------------------------------------------------------------------
+// LoadMidiFile: Read the channel and system exclusive events from a
+// standard midi file (format 0 or 1). Events from all tracks are merged and
+// sorted by time.
+func LoadMidiFile(path string) ([]MidiFileEvent, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 14 || string(data[0:4]) != "MThd" {
+		return nil, errors.New("Not a standard midi file: " + path)
+	}
+
+	hdrLen := int(binary.BigEndian.Uint32(data[4:8]))
+	if hdrLen < 6 || 8+hdrLen > len(data) {
+		return nil, errors.New("Malformed midi file header.")
+	}
+
+	numTracks := int(binary.BigEndian.Uint16(data[10:12]))
+	division := binary.BigEndian.Uint16(data[12:14])
+
+	var raw []smfEvent
+	pos := 8 + hdrLen
+
+	for i := 0; i < numTracks; i++ {
+		if pos+8 > len(data) {
+			return nil, errors.New("Unexpected end of midi file.")
+		}
+		chunkLen := int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
+		chunkEnd := pos + 8 + chunkLen
+		if chunkEnd > len(data) {
+			return nil, errors.New("Unexpected end of midi file.")
+		}
+
+		// Skip unknown chunks.
+		if string(data[pos:pos+4]) != "MTrk" {
+			pos = chunkEnd
+			i--
+			continue
+		}
+
+		events, err := parseTrack(data[pos+8 : chunkEnd])
+		if err != nil {
+			return nil, err
+		}
+		raw = append(raw, events...)
+		pos = chunkEnd
+	}
+
+	// Merge tracks. Stable so events at the same tick keep track order.
+	sort.SliceStable(raw, func(i, j int) bool {
+		return raw[i].tick < raw[j].tick
+	})
+
+	// Convert ticks to seconds.
+	events := make([]MidiFileEvent, 0, len(raw))
+
+	if division&0x8000 != 0 {
+		// SMPTE time: frames per second and ticks per frame.
+		fps := float64(-int8(division >> 8))
+		if fps == 29 {
+			fps = 29.97
+		}
+		secPerTick := 1 / (fps * float64(division&0xff))
+		for _, r := range raw {
+			if r.keep {
+				r.ev.Time = float64(r.tick) * secPerTick
+				events = append(events, r.ev)
+			}
+		}
+		return events, nil
+	}
+
+	tempo := 500000 // 120 bpm.
+	lastTick := int64(0)
+	t := 0.0
+
+	for _, r := range raw {
+		t += float64(r.tick-lastTick) * float64(tempo) / 1e6 / float64(division)
+		lastTick = r.tick
+
+		if r.tempo != 0 {
+			tempo = r.tempo
+		}
+
+		if r.keep {
+			r.ev.Time = t
+			events = append(events, r.ev)
+		}
+	}
+
+	return events, nil
+}
+
+// readVarLen: Read a variable length quantity. Returns the value and the
+// number of bytes read, or 0 bytes if the data is truncated.
+func readVarLen(data []byte) (int64, int) {
+	var val int64
+	for i := 0; i < len(data) && i < 4; i++ {
+		val = val<<7 | int64(data[i]&0x7f)
+		if data[i]&0x80 == 0 {
+			return val, i + 1
+		}
+	}
+	return 0, 0
+}
+
+func parseTrack(data []byte) ([]smfEvent, error) {
+	var events []smfEvent
+	var tick int64
+	var status byte
+	var sysex sysExBuffer
+	errTrunc := errors.New("Truncated midi track.")
+
+	pos := 0
+	for pos < len(data) {
+		delta, n := readVarLen(data[pos:])
+		if n == 0 {
+			return nil, errTrunc
+		}
+		pos += n
+		tick += delta
+
+		if pos >= len(data) {
+			return nil, errTrunc
+		}
+
+		// Running status: data bytes reuse the previous status byte.
+		if data[pos]&0x80 != 0 {
+			status = data[pos]
+			pos++
+		} else if status == 0 {
+			return nil, errors.New("Midi data byte without status.")
+		}
+
+		switch {
+		case status == 0xff:
+			// Meta event.
+			if pos >= len(data) {
+				return nil, errTrunc
+			}
+			metaType := data[pos]
+			length, n := readVarLen(data[pos+1:])
+			if n == 0 || pos+1+n+int(length) > len(data) {
+				return nil, errTrunc
+			}
+			body := data[pos+1+n : pos+1+n+int(length)]
+			pos += 1 + n + int(length)
+
+			if metaType == 0x51 && len(body) == 3 {
+				tempo := int(body[0])<<16 | int(body[1])<<8 | int(body[2])
+				events = append(events, smfEvent{tick: tick, tempo: tempo})
+			} else if metaType == 0x2f {
+				return events, nil
+			}
+			status = 0
+
+		case status == 0xf0 || status == 0xf7:
+			// System exclusive. F0 starts a message, and F7 continues
+			// one that was split across events.
+			length, n := readVarLen(data[pos:])
+			if n == 0 || pos+n+int(length) > len(data) {
+				return nil, errTrunc
+			}
+			body := data[pos+n : pos+n+int(length)]
+			pos += n + int(length)
+
+			if status == 0xf0 {
+				sysex.push(status)
+			}
+			if msg, ok := sysex.pushAll(body); ok {
+				ev := MidiEvent{Type: MidiSysEx, Data: msg}
+				events = append(events, smfEvent{
+					tick: tick, ev: MidiFileEvent{MidiEvent: ev}, keep: true})
+			}
+			status = 0
+
+		default:
+			size := midiDataLen(status)
+			if pos+size > len(data) {
+				return nil, errTrunc
+			}
+			d := data[pos : pos+size]
+			pos += size
+
+			if ev, ok := decodeMidiMessage(status, d); ok {
+				events = append(events, smfEvent{
+					tick: tick, ev: MidiFileEvent{MidiEvent: ev}, keep: true})
+			}
+		}
+	}
+
+	return events, nil
+}
@@ -18,8 +18,7 @@ func NewSound(size int) *Sound {
 func NewSoundFromSample(samp *Sample) *Sound {
 	s := NewSound(samp.Len)
 	for i := 0; i < samp.Len; i++ {
-		s.L[i] = float32(samp.L[i]) / maxVal16
-		s.R[i] = float32(samp.R[i]) / maxVal16
+		s.L[i], s.R[i] = samp.At(i)
 	}
 	return s
 }
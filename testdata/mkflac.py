@@ -0,0 +1,114 @@
+# Minimal flac encoder for testdata/stereo24.flac. Writes known integer PCM
+# using every subframe type and channel assignment the decoder handles.
+# Usage: python3 mkflac.py stereo24.flac
+import hashlib,struct,sys
+
+N=3000; BITS=24; RATE=48000; BS=1024
+def left(i): return abs((i*20000)%8000000-4000000)-2000000
+def right(i):
+    if i>=2048: return 12345
+    return left(i)//2+(i*i*31)%1000-500
+L=[left(i) for i in range(N)]; R=[right(i) for i in range(N)]
+
+class W:
+    def __init__(s): s.bits=[]
+    def u(s,v,n):
+        for k in range(n-1,-1,-1): s.bits.append((v>>k)&1)
+    def i(s,v,n): s.u(v&((1<<n)-1),n)
+    def unary(s,q): s.bits+= [0]*q+[1]
+    def align(s):
+        while len(s.bits)%8: s.bits.append(0)
+    def bytes(s):
+        assert len(s.bits)%8==0
+        return bytes(int(''.join(map(str,s.bits[k:k+8])),2) for k in range(0,len(s.bits),8))
+
+def crc8(b):
+    c=0
+    for x in b:
+        c^=x
+        for _ in range(8): c=((c<<1)^0x07)&0xff if c&0x80 else (c<<1)&0xff
+    return c
+def crc16(b):
+    c=0
+    for x in b:
+        c^=x<<8
+        for _ in range(8): c=((c<<1)^0x8005)&0xffff if c&0x8000 else (c<<1)&0xffff
+    return c
+
+def residual(w,res,order,porder,escape_part=-1):
+    w.u(0,2); w.u(porder,4)
+    n=len(res)+order; plen=n>>porder; k=0
+    for p in range(1<<porder):
+        cnt=plen-(order if p==0 else 0)
+        part=res[k:k+cnt]; k+=cnt
+        if p==escape_part:
+            rb=max(abs(v) for v in part).bit_length()+1
+            w.u(15,4); w.u(rb,5)
+            for v in part: w.i(v,rb)
+            continue
+        mean=sum(abs(v) for v in part)//max(cnt,1)
+        param=min(max(mean.bit_length()-1,0),14)
+        w.u(param,4)
+        for v in part:
+            z=(v<<1)^(v>>63) if v>=0 else ((-v)<<1)-1
+            w.unary(z>>param); w.u(z&((1<<param)-1),param)
+
+def sub_const(w,x,bits):
+    w.u(0,1); w.u(0,6); w.u(0,1); w.i(x[0],bits)
+def sub_verbatim(w,x,bits):
+    w.u(0,1); w.u(1,6); w.u(0,1)
+    for v in x: w.i(v,bits)
+FIXED=[[],[1],[2,-1],[3,-3,1],[4,-6,4,-1]]
+def pred(x,coef,shift):
+    o=len(coef)
+    return [x[n]-(sum(c*x[n-1-j] for j,c in enumerate(coef))>>shift) for n in range(o,len(x))]
+def sub_fixed(w,x,bits,order,porder,wasted=0,escape_part=-1):
+    w.u(0,1); w.u(8+order,6)
+    if wasted:
+        w.u(1,1); w.unary(wasted-1); x=[v>>wasted for v in x]; bits-=wasted
+    else: w.u(0,1)
+    for v in x[:order]: w.i(v,bits)
+    residual(w,pred(x,FIXED[order],0),order,porder,escape_part)
+def sub_lpc(w,x,bits,coef,prec,shift,porder):
+    o=len(coef)
+    w.u(0,1); w.u(31+o,6); w.u(0,1)
+    for v in x[:o]: w.i(v,bits)
+    w.u(prec-1,4); w.i(shift,5)
+    for c in coef: w.i(c,prec)
+    residual(w,pred(x,coef,shift),o,porder)
+
+def frame(num,a,b,chcode,subs):
+    w=W(); n=len(a)
+    w.u(0xfff8,16)
+    bscode=10 if n==1024 else 7
+    w.u(bscode,4); w.u(10,4); w.u(chcode,4); w.u(6,3); w.u(0,1)
+    w.u(num,8)
+    if bscode==7: w.u(n-1,16)
+    w.u(crc8(w.bytes()),8)
+    subs(w)
+    w.align()
+    w.u(crc16(w.bytes()),16)
+    return w.bytes()
+
+frames=[]
+# Frame 0: mid/side. Mid is LPC order 2, side is verbatim.
+a,b=L[0:1024],R[0:1024]
+mid=[(x+y)>>1 for x,y in zip(a,b)]; side=[x-y for x,y in zip(a,b)]
+frames.append(frame(0,a,b,10,lambda w:(sub_lpc(w,mid,24,[2,-1],3,0,2),sub_verbatim(w,side,25))))
+# Frame 1: left/side. Left is fixed order 3 with wasted bits, side is fixed
+# order 2 with an escaped partition.
+a,b=L[1024:2048],R[1024:2048]
+side=[x-y for x,y in zip(a,b)]
+frames.append(frame(1,a,b,8,lambda w:(sub_fixed(w,a,24,3,1,wasted=5),sub_fixed(w,side,25,2,2,escape_part=1))))
+# Frame 2: independent. Left is LPC order 4 with a shift, right is constant.
+a,b=L[2048:],R[2048:]
+frames.append(frame(2,a,b,1,lambda w:(sub_lpc(w,a,24,[64,-32,0,0],8,5,3),sub_const(w,b,24))))
+
+pcm=b''.join(struct.pack('<i',v)[:3] for i in range(N) for v in (L[i],R[i]))
+md5=hashlib.md5(pcm).digest()
+si=struct.pack('>HH',1024,1024)+(0).to_bytes(3,'big')+(0).to_bytes(3,'big')
+x=(RATE<<44)|((2-1)<<41)|((BITS-1)<<36)|N
+si+=x.to_bytes(8,'big')+md5
+out=b'fLaC'+bytes([0x80])+len(si).to_bytes(3,'big')+si+b''.join(frames)
+open(sys.argv[1],'wb').write(out)
+print(len(out))
@@ -1,31 +1,52 @@
 package jlsampler
 
 import (
+	"errors"
 	"fmt"
-	"github.com/johnnylee/flac"
 	"os"
+	"os/user"
+	"path/filepath"
 )
 
 // ----------------------------------------------------------------------------
 // Global constants.
 const (
 	version    = 0.91    // Version number.
-	sampleRate = 48000   // Fixed sample rate.
+	sampleRate = 48000   // Default sample rate.
 	maxVal16   = 32767   // 16-bit maximum sample value.
 	maxVal24   = 8388607 // 24-bit maximum sample value.
 	ampCutoff  = 1e-5    // Cut-off amplitude of decaying sample.
 )
 
 // ----------------------------------------------------------------------------
-func LoadFlac(path string) (*Sample, error) {
-	L, R, err := flac.LoadInt16(path)
-	if err != nil {
-		return nil, err
+// pitchBendValue: Scale a signed 14-bit pitch bend to the range -1 to 1.
+func pitchBendValue(bend int32) float64 {
+	if bend < 0 {
+		return float64(bend) / 8192.0
 	}
-	return NewSampleFromArrays(L, R), nil
+	return float64(bend) / 8191.0
 }
 
 // ----------------------------------------------------------------------------
 func Println(a ...interface{}) {
 	os.Stderr.Write([]byte(fmt.Sprintln(a...)))
 }
+
+// findFile: Find a file named in defaults.js, first relative to the
+// sample-set directory (the working directory when loading), then in
+// ~/.jlsampler.
+func findFile(name string) (string, error) {
+	if _, err := os.Stat(name); err == nil || filepath.IsAbs(name) {
+		return name, nil
+	}
+
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(usr.HomeDir, ".jlsampler", name)
+	if _, err := os.Stat(path); err != nil {
+		return "", errors.New("File not found: " + name)
+	}
+	return path, nil
+}
@@ -0,0 +1,115 @@
+package jlsampler
+
+import (
+	"sync/atomic"
+)
+
+// Voice stealing policies. See Controls.StealMode.
+const (
+	StealOldest   int8 = iota // Steal the voice started longest ago.
+	StealQuietest             // Steal the voice with the lowest amplitude.
+	StealSameKey              // Steal the oldest voice on the same key first.
+	numStealModes
+)
+
+var stealModeNames = []string{"oldest", "quietest", "same-key"}
+
+func stealModeName(mode int8) string {
+	if mode < 0 || mode >= numStealModes {
+		return "unknown"
+	}
+	return stealModeNames[mode]
+}
+
+// ----------------------------------------------------------------------------
+// Functions below are only called from the jack callback.
+
+// numVoices: The number of playing voices that haven't been stolen.
+func (s *Sampler) numVoices() int {
+	n := 0
+	for _, ks := range s.keySamplers {
+		if ks == nil {
+			continue
+		}
+		for _, ps := range ks.playing {
+			if !ps.stolen {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// stealVoices: Make room for a new voice on the given key if the number of
+// voices is limited by Controls.MaxVoices. Stolen voices fade out quickly
+// with Controls.TauSteal rather than being cut off.
+func (s *Sampler) stealVoices(key int) {
+	maxVoices := s.controls.MaxVoices
+	if maxVoices <= 0 {
+		return
+	}
+
+	for n := s.numVoices(); n >= maxVoices; n-- {
+		ps := s.findVictim(key)
+		if ps == nil {
+			return
+		}
+		ps.Steal(float32(s.controls.TauSteal))
+		atomic.AddInt64(&s.stolen, 1)
+	}
+}
+
+// findVictim: Choose a voice to steal according to Controls.StealMode.
+func (s *Sampler) findVictim(key int) *PlayingSample {
+	mode := s.controls.StealMode
+
+	if mode == StealSameKey {
+		if ks := s.keySamplers[key]; ks != nil {
+			if ps := oldestVoice(ks.playing, nil); ps != nil {
+				return ps
+			}
+		}
+	}
+
+	var victim *PlayingSample
+	for _, ks := range s.keySamplers {
+		if ks == nil {
+			continue
+		}
+		if mode == StealQuietest {
+			victim = quietestVoice(ks.playing, victim)
+		} else {
+			victim = oldestVoice(ks.playing, victim)
+		}
+	}
+
+	return victim
+}
+
+// oldestVoice: Return the oldest unstolen voice in playing, or victim if it
+// is older.
+func oldestVoice(playing []*PlayingSample, victim *PlayingSample) *PlayingSample {
+	for _, ps := range playing {
+		if !ps.stolen && (victim == nil || ps.serial < victim.serial) {
+			victim = ps
+		}
+	}
+	return victim
+}
+
+// quietestVoice: Return the quietest unstolen voice in playing, or victim if
+// it is quieter.
+func quietestVoice(playing []*PlayingSample, victim *PlayingSample) *PlayingSample {
+	for _, ps := range playing {
+		if !ps.stolen && (victim == nil || ps.Level() < victim.Level()) {
+			victim = ps
+		}
+	}
+	return victim
+}
+
+// StolenVoices: The number of voices stolen since the sampler started. This
+// may be called from any goroutine.
+func (s *Sampler) StolenVoices() int64 {
+	return atomic.LoadInt64(&s.stolen)
+}
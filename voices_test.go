@@ -0,0 +1,77 @@
+package jlsampler
+
+import (
+	"math"
+	"testing"
+)
+
+func newTestSampler() *Sampler {
+	s := new(Sampler)
+	s.controls = NewControls(s)
+	s.outRate = 48000
+	s.controls.outRate = 48000
+	s.keySamplers = make([]*KeySampler, 128)
+	for _, key := range []int{60, 62, 64} {
+		ks := NewKeySampler(s.controls, key)
+		ks.AddLayer()
+		ks.AddSample(newTestSample(1), 0)
+		ks.AddReleaseSample(newTestSample(1), 0)
+		s.keySamplers[key] = ks
+	}
+	return s
+}
+
+// Release voices started after a note are stolen after it.
+func TestStealOldestRelease(t *testing.T) {
+	s := newTestSampler()
+	s.controls.StealMode = StealOldest
+
+	s.applyEvent(&Event{Type: EventNoteOn, Note: 60, Value: 1})
+	s.applyEvent(&Event{Type: EventNoteOn, Note: 62, Value: 1})
+	s.applyEvent(&Event{Type: EventNoteOff, Note: 62})
+
+	playing := s.keySamplers[62].playing
+	if len(playing) != 2 || !playing[1].release {
+		t.Fatalf("Expected a release voice, got %d voices.", len(playing))
+	}
+
+	victim := s.findVictim(64)
+	if victim != s.keySamplers[60].playing[0] {
+		t.Fatal("The oldest note wasn't chosen.")
+	}
+
+	victim.stolen = true
+	if s.findVictim(64) != s.keySamplers[62].playing[0] {
+		t.Fatal("The release voice was stolen before the second note.")
+	}
+}
+
+// A half pedal decays an enveloped voice with its own release, and the
+// envelope release replaces the half pedal decay when the pedal lifts.
+func TestHalfPedalEnvelope(t *testing.T) {
+	s := newTestSampler()
+	c := s.controls
+	c.Envelope = true
+	c.HalfPedalLow = 0.2
+	c.HalfPedalHigh = 0.8
+
+	c.UpdateSustain(1)
+	s.applyEvent(&Event{Type: EventNoteOn, Note: 60, Value: 1})
+	s.applyEvent(&Event{Type: EventNoteOff, Note: 60})
+	ps := s.keySamplers[60].playing[0]
+
+	c.UpdateSustain(0.5)
+	ps.halfDamp(c.pedalLift)
+	want := float32(math.Sqrt(ps.env.releaseFactor()))
+	if !ps.damped || math.Abs(float64(ps.tau-want)) > 1e-6 {
+		t.Fatalf("Half pedal decay is %v, want %v.", ps.tau, want)
+	}
+
+	c.UpdateSustain(0)
+	ps.Release()
+	c.UpdateSustain(0.5)
+	ps.halfDamp(c.pedalLift)
+	if ps.tau != 0 || ps.env.stage != envRelease {
+		t.Fatalf("Releasing voice has half pedal decay %v.", ps.tau)
+	}
+}
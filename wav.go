@@ -0,0 +1,113 @@
+package jlsampler
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+)
+
+const (
+	wavFormatPcm        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xfffe
+)
+
+// ----------------------------------------------------------------------------
+// LoadWav: Load a PCM or float wav file, including WAVE_FORMAT_EXTENSIBLE.
+func LoadWav(path string) (*PcmData, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 12 ||
+		string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, errors.New("Not a wav file: " + path)
+	}
+
+	var fmtChunk, dataChunk []byte
+	var loop *SampleLoop
+
+	err = forEachChunk(data[12:], binary.LittleEndian,
+		func(id string, body []byte) {
+			switch id {
+			case "fmt ":
+				fmtChunk = body
+			case "data":
+				dataChunk = body
+			case "smpl":
+				loop = parseSmplChunk(body)
+			}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fmtChunk) < 16 || dataChunk == nil {
+		return nil, errors.New("Missing fmt or data chunk: " + path)
+	}
+
+	le := binary.LittleEndian
+	format := le.Uint16(fmtChunk[0:2])
+	nChans := int(le.Uint16(fmtChunk[2:4]))
+	rate := int(le.Uint32(fmtChunk[4:8]))
+	blockAlign := int(le.Uint16(fmtChunk[12:14]))
+	bits := int(le.Uint16(fmtChunk[14:16]))
+
+	if format == wavFormatExtensible {
+		// The sub-format GUID begins with the format code.
+		if len(fmtChunk) < 40 {
+			return nil, errors.New("Malformed extensible wav: " + path)
+		}
+		format = le.Uint16(fmtChunk[24:26])
+		if validBits := int(le.Uint16(fmtChunk[18:20])); validBits != 0 {
+			bits = validBits
+		}
+	}
+
+	if format != wavFormatPcm && format != wavFormatFloat {
+		return nil, errors.New("Unsupported wav format: " + path)
+	}
+
+	if nChans < 1 || blockAlign%nChans != 0 {
+		return nil, errors.New("Malformed wav header: " + path)
+	}
+
+	// Samples are stored left-justified in containers of blockAlign/nChans
+	// bytes.
+	size := blockAlign / nChans
+	chans, err := decodePcm(
+		dataChunk, nChans, size, format == wavFormatFloat, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PcmData{Rate: rate, Bits: bits, Chans: chans, Loop: loop}, nil
+}
+
+// forEachChunk: Call fn for each RIFF or IFF chunk in data. Chunks are
+// padded to an even length.
+func forEachChunk(data []byte, order binary.ByteOrder,
+	fn func(id string, body []byte)) error {
+
+	pos := 0
+	for pos+8 <= len(data) {
+		id := string(data[pos : pos+4])
+		size := int(order.Uint32(data[pos+4 : pos+8]))
+		pos += 8
+
+		if size < 0 || pos+size > len(data) {
+			// Some writers leave the data size unset. Use what's there.
+			if id == "data" || id == "SSND" {
+				size = len(data) - pos
+			} else {
+				return errors.New("Truncated chunk: " + id)
+			}
+		}
+
+		fn(id, data[pos:pos+size])
+		pos += size + size%2
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package jlsampler
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+)
+
+// ----------------------------------------------------------------------------
+// WavWriter: Write stereo 32-bit float wav files.
+type WavWriter struct {
+	f      *os.File
+	frames int
+	buf    []byte
+}
+
+func NewWavWriter(path string, rate int) (*WavWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := new(WavWriter)
+	w.f = f
+
+	// The sizes are filled in by Close.
+	hdr := make([]byte, 44)
+	copy(hdr[0:], "RIFF")
+	copy(hdr[8:], "WAVE")
+	copy(hdr[12:], "fmt ")
+	binary.LittleEndian.PutUint32(hdr[16:], 16)
+	binary.LittleEndian.PutUint16(hdr[20:], 3) // IEEE float.
+	binary.LittleEndian.PutUint16(hdr[22:], 2)
+	binary.LittleEndian.PutUint32(hdr[24:], uint32(rate))
+	binary.LittleEndian.PutUint32(hdr[28:], uint32(rate*8))
+	binary.LittleEndian.PutUint16(hdr[32:], 8)
+	binary.LittleEndian.PutUint16(hdr[34:], 32)
+	copy(hdr[36:], "data")
+
+	if _, err = f.Write(hdr); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Write: Write interleaved frames from the left and right channels.
+func (w *WavWriter) Write(L, R []float32) error {
+	n := len(L) * 8
+	if cap(w.buf) < n {
+		w.buf = make([]byte, n)
+	}
+	buf := w.buf[:n]
+
+	for i := range L {
+		binary.LittleEndian.PutUint32(buf[8*i:], math.Float32bits(L[i]))
+		binary.LittleEndian.PutUint32(buf[8*i+4:], math.Float32bits(R[i]))
+	}
+
+	w.frames += len(L)
+	_, err := w.f.Write(buf)
+	return err
+}
+
+// Close: Fill in the header sizes and close the file.
+func (w *WavWriter) Close() error {
+	defer w.f.Close()
+
+	dataSize := uint32(w.frames * 8)
+	b := make([]byte, 4)
+
+	binary.LittleEndian.PutUint32(b, 36+dataSize)
+	if _, err := w.f.WriteAt(b, 4); err != nil {
+		return err
+	}
+
+	binary.LittleEndian.PutUint32(b, dataSize)
+	if _, err := w.f.WriteAt(b, 40); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package jlsampler
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// WidthFile: Optional per-sample stereo width, read from width.js. The file
+// maps sample paths to widths. 0 is mono, 1 is unchanged.
+type WidthFile struct {
+	vals map[string]float64
+}
+
+func LoadWidthFile() *WidthFile {
+	wf := new(WidthFile)
+
+	f, err := os.Open("width.js")
+	if err != nil {
+		// The file is optional.
+		return wf
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	if err = decoder.Decode(&wf.vals); err != nil {
+		Println("Error decoding width file:", err)
+	}
+
+	return wf
+}
+
+func (wf *WidthFile) GetWidth(filename string) float64 {
+	value, ok := wf.vals[filename]
+	if !ok {
+		return 1.0
+	}
+	return value
+}